@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+	"github.com/urfave/cli/v2"
+)
+
+// Exit code used when a translation is refused because it would exceed a budget,
+// or the remaining allowance of the account.
+const exitBudgetExceeded = 3
+
+// How long the numbers returned by the usage endpoint are trusted before asking again.
+const usageRefreshInterval = 10 * time.Second
+
+// Character budgets, as set in the settings file; zero means no limit.
+type budgetSetting struct {
+	PerInvocation	int				`json:"per_invocation,omitempty"`	// Maximum characters for a single run.
+	PerDay			int				`json:"per_day,omitempty"`			// Maximum characters per (local) calendar day.
+	PerProject		map[string]int	`json:"per_project,omitempty"`		// Maximum characters per project and calendar month.
+	Reserve			int				`json:"reserve,omitempty"`			// Characters of the account allowance that must always be left untouched.
+}
+
+// The quota guard is consulted before each request, and refuses to go ahead if the
+// request would exceed any of the budgets, or what is left of the account's allowance.
+// It can be shared by translations running concurrently: the characters of each request
+// are reserved when it is checked, until they are either recorded or released.
+type quotaGuard struct {
+	mu		sync.Mutex
+	budget	budgetSetting
+	project	string
+	user	string		// Recorded in the ledger.
+	ledger	*spendLedger
+	usage	func() (deepl.DeepLUsageResponse, error)	// Retrieves the live account usage; nil skips that check.
+
+	spentInvocation	int	// Characters spent in this run.
+	spentToday		int	// Characters spent today, according to the ledger.
+	spentProject	int	// Characters spent this month on the project, according to the ledger.
+	reserved		int	// Characters of the requests checked but neither recorded nor released yet.
+
+	lastUsage		deepl.DeepLUsageResponse
+	lastUsageTime	time.Time
+	spentSinceUsage	int	// Characters spent since lastUsage was retrieved.
+}
+
+// Creates a guard, summing up what the ledger says was already spent.
+func newQuotaGuard(budget budgetSetting, project, user string, ledger *spendLedger, usage func() (deepl.DeepLUsageResponse, error)) (*quotaGuard, error) {
+	g := &quotaGuard{
+		budget:		budget,
+		project:	project,
+		user:		user,
+		ledger:		ledger,
+		usage:		usage,
+	}
+	now := time.Now()
+	today := now.Format(time.DateOnly)
+	month := now.Format("2006-01")
+	err := ledger.each(func(r ledgerRecord) {
+		local := r.Time.Local()
+		if local.Format(time.DateOnly) == today {
+			g.spentToday += r.Characters
+		}
+		if project != "" && r.Project == project && local.Format("2006-01") == month {
+			g.spentProject += r.Characters
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Checks whether characters more can be spent, and reserves them if so; they must then be
+// either recorded or released. The error carries exitBudgetExceeded.
+func (g *quotaGuard) check(characters int) error {
+	g.mu.Lock()
+	if err := g.budgetsError(characters); err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	stale := g.usage != nil && time.Since(g.lastUsageTime) > usageRefreshInterval
+	spent := g.spentSinceUsage
+	g.mu.Unlock()
+	if stale {
+		// without the lock, so that the other translations do not wait for DeepL as well.
+		usage, err := g.usage()
+		if err != nil {
+			return fmt.Errorf("%s (occurred while checking the remaining allowance)", err)
+		}
+		g.mu.Lock()
+		// what was spent in the meantime may not be counted by DeepL yet; better assume it is not.
+		g.lastUsage, g.lastUsageTime, g.spentSinceUsage = usage, time.Now(), g.spentSinceUsage-spent
+		g.mu.Unlock()
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// other translations may have reserved characters in the meantime.
+	if err := g.budgetsError(characters); err != nil {
+		return err
+	}
+	if g.usage != nil && g.lastUsage.CharacterLimit != 0 {
+		used := g.lastUsage.CharacterCount + g.spentSinceUsage + g.reserved
+		remaining := g.lastUsage.CharacterLimit - used - g.budget.Reserve
+		if characters > remaining {
+			return cli.Exit(fmt.Sprintf("refusing to send %d characters: only %d are left of the account allowance (%d used of %d, %d reserved)",
+				characters, max(remaining, 0), used, g.lastUsage.CharacterLimit, g.budget.Reserve), exitBudgetExceeded)
+		}
+	}
+	g.reserved += characters
+	return nil
+}
+
+// Gives back characters reserved by check, for a request that failed.
+func (g *quotaGuard) release(characters int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.reserved = max(g.reserved-characters, 0)
+}
+
+// Checks the local budgets only, without asking DeepL.
+func (g *quotaGuard) checkBudgets(characters int) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.budgetsError(characters)
+}
+
+// Checks the local budgets; characters being sent by other requests count as spent.
+func (g *quotaGuard) budgetsError(characters int) error {
+	if spent := g.spentInvocation + g.reserved; g.budget.PerInvocation > 0 && spent+characters > g.budget.PerInvocation {
+		return cli.Exit(fmt.Sprintf("refusing to send %d characters: this would exceed the budget of %d characters per invocation (%d already spent)",
+			characters, g.budget.PerInvocation, spent), exitBudgetExceeded)
+	}
+	if spent := g.spentToday + g.reserved; g.budget.PerDay > 0 && spent+characters > g.budget.PerDay {
+		return cli.Exit(fmt.Sprintf("refusing to send %d characters: this would exceed the daily budget of %d characters (%d already spent today)",
+			characters, g.budget.PerDay, spent), exitBudgetExceeded)
+	}
+	if limit, ok := g.budget.PerProject[g.project]; ok && g.project != "" && limit > 0 && g.spentProject+g.reserved+characters > limit {
+		return cli.Exit(fmt.Sprintf("refusing to send %d characters: this would exceed the monthly budget of %d characters for project %q (%d already spent this month)",
+			characters, limit, g.project, g.spentProject+g.reserved), exitBudgetExceeded)
+	}
+	return nil
+}
+
+// Records (part of) a successful request, both in the guard and in the ledger, turning its
+// reserved characters into spent ones; the time, user and project are filled in here.
+func (g *quotaGuard) record(r ledgerRecord) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.reserved = max(g.reserved-r.Characters, 0)
+	g.spentInvocation += r.Characters
+	g.spentToday += r.Characters
+	g.spentProject += r.Characters
+	g.spentSinceUsage += r.Characters
+	r.Time = time.Now().UTC()
+	r.User = g.user
+	r.Project = g.project
+	return g.ledger.append(r)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+	"github.com/urfave/cli/v2"
+)
+
+func TestQuotaGuard(t *testing.T) {
+	ledger := &spendLedger{path: filepath.Join(t.TempDir(), "ledger.jsonl")}
+	yesterday := time.Now().AddDate(0, 0, -1).UTC()
+	for _, r := range []ledgerRecord{
+		{Time: yesterday, Project: "docs", Characters: 1000},
+		{Time: time.Now().UTC(), Project: "docs", Characters: 300},
+		{Time: time.Now().UTC(), Project: "marketing", Characters: 200},
+	} {
+		if err := ledger.append(r); err != nil {
+			t.Fatalf("Failed to write to the ledger: %s", err)
+		}
+	}
+
+	usageCalls := 0
+	usage := func() (deepl.DeepLUsageResponse, error) {
+		usageCalls++
+		return deepl.DeepLUsageResponse{CharacterCount: 9000, CharacterLimit: 10000}, nil
+	}
+	budget := budgetSetting{
+		PerInvocation:	600,
+		PerDay:			1000,
+		PerProject:		map[string]int{"docs": 2000},
+		Reserve:		100,
+	}
+	guard, err := newQuotaGuard(budget, "docs", "tester", ledger, usage)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expectedProject := 300
+	if yesterday.Local().Month() == time.Now().Month() {
+		expectedProject += 1000
+	}
+	if guard.spentToday != 500 || guard.spentProject != expectedProject {
+		t.Errorf("Unexpected spending from the ledger: today %d, project %d", guard.spentToday, guard.spentProject)
+	}
+
+	if err := guard.check(400); err != nil {
+		t.Fatalf("400 characters should fit every budget: %s", err)
+	}
+	if err := guard.record(ledgerRecord{TargetLang: "DE", Characters: 400}); err != nil {
+		t.Fatalf("Failed to record spending: %s", err)
+	}
+	assertExitCode := func(err error, what string) {
+		var exitErr cli.ExitCoder
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitBudgetExceeded {
+			t.Errorf("Exceeding the %s should fail with exit code %d, got: %v", what, exitBudgetExceeded, err)
+		}
+	}
+	assertExitCode(guard.check(300), "per-invocation budget")
+	assertExitCode(guard.check(150), "daily budget")
+
+	guard.budget = budgetSetting{Reserve: 100}
+	assertExitCode(guard.check(501), "remaining allowance")
+	if err := guard.check(500); err != nil {
+		t.Errorf("500 characters should still fit the allowance: %s", err)
+	}
+	if usageCalls != 1 {
+		t.Errorf("The usage should have been retrieved once, not %d times", usageCalls)
+	}
+	// until they are recorded or released, the characters checked are reserved.
+	assertExitCode(guard.check(1), "remaining allowance, with the rest reserved")
+	guard.release(500)
+	if err := guard.check(500); err != nil {
+		t.Errorf("Released characters should be available again: %s", err)
+	}
+
+	// retrieving the usage does not hold up the other translations.
+	blocked, unblock := make(chan bool), make(chan bool)
+	guard, _ = newQuotaGuard(budgetSetting{}, "", "tester", ledger, func() (deepl.DeepLUsageResponse, error) {
+		blocked <- true
+		<-unblock
+		return deepl.DeepLUsageResponse{CharacterLimit: 10000}, nil
+	})
+	done := make(chan error)
+	go func() { done <- guard.check(10) }()
+	<-blocked
+	if err := guard.checkBudgets(10); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	close(unblock)
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestSummariseLedger(t *testing.T) {
+	ledger := &spendLedger{path: filepath.Join(t.TempDir(), "ledger.jsonl")}
+	day1 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+	for _, r := range []ledgerRecord{
+		{Time: day1, User: "alice", Project: "docs", SourceLang: "EN", TargetLang: "DE", File: "a.txt", Characters: 100, Request: "r1"},
+		{Time: day1, User: "alice", Project: "docs", SourceLang: "EN", TargetLang: "DE", File: "b.txt", Characters: 20, Request: "r1"},
+		{Time: day1, User: "bob", Project: "docs", SourceLang: "EN", TargetLang: "JA", Characters: 50},
+		{Time: day2, User: "alice", TargetLang: "DE", Characters: 500},
+	} {
+		ledger.append(r)
+	}
+
+	summary, err := summariseLedger(ledger, defaultStatsDimensions, statsFilter{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := map[string][]statsRow{
+		"day":		{{"2024-03-01", 2, 170}, {"2024-03-02", 1, 500}},
+		"project":	{{"(none)", 1, 500}, {"docs", 2, 170}},
+		"pair":		{{"(none) ⇒ DE", 1, 500}, {"EN ⇒ DE", 1, 120}, {"EN ⇒ JA", 1, 50}},
+		"user":		{{"alice", 2, 620}, {"bob", 1, 50}},
+	}
+	if !reflect.DeepEqual(summary, expected) {
+		t.Errorf("Unexpected summary\nExpected: %v\nActual: %v", expected, summary)
+	}
+
+	summary, _ = summariseLedger(ledger, []string{"user"}, statsFilter{Until: day2, Project: "docs"})
+	if len(summary["user"]) != 2 || summary["user"][0].Characters != 120 {
+		t.Errorf("Filters were not applied: %v", summary)
+	}
+	if _, err := summariseLedger(ledger, []string{"weekday"}, statsFilter{}); err == nil {
+		t.Errorf("Unknown dimensions should return an error")
+	}
+}
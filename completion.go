@@ -0,0 +1,368 @@
+// This file generates the shell completion scripts, and answers the requests they make.
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+	"github.com/urfave/cli/v2"
+)
+
+// Hidden command called by the completion scripts, with the words typed so far; the last one
+// is the word being completed (possibly empty).
+const completeCommandName = "__complete"
+
+// Shells for which there is a completion script.
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// Values of the settings that only take a fixed set of them, with their descriptions.
+// settingValidators has the final say; these are offered for completion and by `config init`.
+var settingChoices = map[string]map[string]string{
+	"type": {
+		"source":	"languages valid as source_lang",
+		"target":	"languages valid as target_lang",
+		"all":		"both, and where each one can be used",
+	},
+	"tag_handling": {
+		"xml":	"XML input",
+		"html":	"HTML input",
+	},
+	"split_sentences": {
+		"0":			"no splitting at all",
+		"1":			"split on punctuation and on newlines",
+		"nonewlines":	"split on punctuation only",
+	},
+	"preserve_formatting": {
+		"0":	"let DeepL correct the formatting",
+		"1":	"respect the original formatting",
+	},
+	"formality": {
+		"default":		"let DeepL decide",
+		"more":			"formal",
+		"less":			"informal",
+		"prefer_more":	"formal, where supported",
+		"prefer_less":	"informal, where supported",
+	},
+	"segment": {
+		segmentBySentence:	"one segment per sentence",
+		segmentByParagraph:	"one segment per paragraph",
+		segmentByNone:		"the whole text is one segment",
+	},
+}
+
+// Values of flags that are not settings, by flag name.
+var flagChoices = map[string]map[string]string{
+	"input-format": {
+		inputFormatText:	"each input is one text",
+		inputFormatJSONL:	"one JSON record per line",
+	},
+	"output": {
+		outputTable:	"for humans",
+		outputJSON:		"for programs",
+	},
+}
+
+// One completion candidate.
+type completion struct {
+	Value		string
+	Description	string	// Shown by the shells that can.
+}
+
+// Returns the candidates among choices that start with prefix (ignoring case), sorted.
+func completeChoices(choices map[string]string, prefix string) []completion {
+	var result []completion
+	for _, value := range sortedChoices(choices) {
+		if len(value) >= len(prefix) && strings.EqualFold(value[:len(prefix)], prefix) {
+			result = append(result, completion{Value: value, Description: choices[value]})
+		}
+	}
+	return result
+}
+
+// Finds a flag by how it was typed (e.g. "-t", "--target_lang" or "--target_lang=DE").
+func findFlag(word string, flags []cli.Flag) cli.Flag {
+	name, _, _ := strings.Cut(strings.TrimLeft(word, "-"), "=")
+	for _, flag := range flags {
+		for _, n := range flag.Names() {
+			if n == name {
+				return flag
+			}
+		}
+	}
+	return nil
+}
+
+func findCommand(commands []*cli.Command, name string) *cli.Command {
+	for _, command := range commands {
+		if command.HasName(name) {
+			return command
+		}
+	}
+	return nil
+}
+
+// Works out what the word being completed (the last of words, which start after the program
+// name) can be: a command, a flag, the value of a flag, or an argument.
+// An empty result lets the shell complete file names instead.
+func completeWords(app *cli.App, setting Setting, words []string) []completion {
+	current := words[len(words)-1]
+	if current == `""` {
+		// Windows PowerShell drops empty arguments, so the script passes this instead.
+		current = ""
+	}
+	flags, commands := app.Flags, app.Commands
+	var path, args []string	// Names of the commands, and arguments given to the last one.
+	var valueOf cli.Flag	// Flag whose value comes next.
+	for _, word := range words[:len(words)-1] {
+		switch {
+			case valueOf != nil:
+				// bash passes "--flag=value" as "--flag", "=" and "value".
+				if word != "=" {
+					valueOf = nil
+				}
+			case strings.HasPrefix(word, "-") && len(word) > 1:
+				flag := findFlag(word, flags)
+				if _, isBool := flag.(*cli.BoolFlag); flag != nil && !isBool && !strings.Contains(word, "=") {
+					valueOf = flag
+				}
+			default:
+				if command := findCommand(commands, word); command != nil && len(args) == 0 {
+					path = append(path, command.Name)
+					flags, commands = command.Flags, command.Subcommands
+				} else {
+					args = append(args, word)
+				}
+		}
+	}
+
+	if valueOf != nil {
+		return flagValues(valueOf, setting, current)
+	}
+	if name, value, ok := strings.Cut(current, "="); ok && strings.HasPrefix(name, "-") {
+		flag := findFlag(name, flags)
+		if flag == nil {
+			return nil
+		}
+		var result []completion
+		for _, c := range flagValues(flag, setting, value) {
+			result = append(result, completion{Value: name + "=" + c.Value, Description: c.Description})
+		}
+		return result
+	}
+	if strings.HasPrefix(current, "-") {
+		return completeFlags(flags, current)
+	}
+
+	var result []completion
+	if len(args) == 0 {
+		for _, command := range commands {
+			if command.Hidden {
+				continue
+			}
+			for _, name := range command.Names() {
+				if strings.HasPrefix(name, current) {
+					result = append(result, completion{Value: name, Description: command.Usage})
+				}
+			}
+		}
+	}
+	return append(result, completeArgs(strings.Join(path, " "), args, current)...)
+}
+
+// Returns the flags starting with prefix, as typed: "-x" for single letters, "--name" otherwise.
+func completeFlags(flags []cli.Flag, prefix string) []completion {
+	var result []completion
+	for _, flag := range flags {
+		var usage string
+		if f, ok := flag.(cli.DocGenerationFlag); ok {
+			usage, _, _ = strings.Cut(f.GetUsage(), "\n")
+		}
+		for _, name := range flag.Names() {
+			typed := "--" + name
+			if len(name) == 1 {
+				typed = "-" + name
+			}
+			if strings.HasPrefix(typed, prefix) {
+				result = append(result, completion{Value: typed, Description: usage})
+			}
+		}
+	}
+	return result
+}
+
+// Returns the values that a flag can take, starting with prefix.
+func flagValues(flag cli.Flag, setting Setting, prefix string) []completion {
+	name := flag.Names()[0]
+	if choices, ok := flagChoices[name]; ok {
+		return completeChoices(choices, prefix)
+	}
+	key := name
+	if k, ok := globalFlagSettings[name]; ok {
+		key = k
+	} else if k, ok := translateFlagSettings[name]; ok {
+		key = k
+	}
+	switch key {
+		case "source_lang", "from":
+			return completeChoices(cachedLanguageChoices(func(l *languageLists) []deepl.DeepLLanguagesResponse { return l.Source }), prefix)
+		case "target_lang":
+			// several languages are separated by commas; only the last one is being typed.
+			before := ""
+			if i := strings.LastIndex(prefix, ","); i >= 0 {
+				before, prefix = prefix[:i+1], prefix[i+1:]
+			}
+			var result []completion
+			for _, c := range completeChoices(cachedLanguageChoices(func(l *languageLists) []deepl.DeepLLanguagesResponse { return l.Target }), prefix) {
+				result = append(result, completion{Value: before + c.Value, Description: c.Description})
+			}
+			return result
+		case "profile":
+			choices := make(map[string]string, len(setting.Profiles))
+			for name := range setting.Profiles {
+				choices[name] = ""
+			}
+			return completeChoices(choices, prefix)
+		case "glossary":
+			return completeChoices(cachedGlossaryChoices(), prefix)
+		default:
+			return completeChoices(settingChoices[key], prefix)
+	}
+}
+
+// Returns the arguments that a command can take, starting with prefix, given those that came before.
+func completeArgs(command string, args []string, prefix string) []completion {
+	switch {
+		case command == "completion" && len(args) == 0:
+			choices := make(map[string]string, len(completionShells))
+			for _, shell := range completionShells {
+				choices[shell] = ""
+			}
+			return completeChoices(choices, prefix)
+		case (command == "config get" || command == "config set" || command == "config unset") && len(args) == 0:
+			choices := make(map[string]string)
+			for _, key := range settingKeys() {
+				choices[key] = ""
+			}
+			return completeChoices(choices, prefix)
+		case command == "config set" && len(args) == 1:
+			return completeChoices(settingChoices[args[0]], prefix)
+	}
+	return nil
+}
+
+// Language codes from the cache, however old; DeepL is not asked, to keep completion fast.
+func cachedLanguageChoices(list func(*languageLists) []deepl.DeepLLanguagesResponse) map[string]string {
+	path, err := languagesCachePath()
+	if err != nil {
+		return nil
+	}
+	langs, err := readLanguagesCache(path)
+	if err != nil || langs == nil {
+		return nil
+	}
+	return languageChoices(list(langs))
+}
+
+// Names of the glossaries from the cache, however old. Neither DeepL nor the token source
+// (which may be a command, e.g. a password manager asking for a passphrase) is touched.
+func cachedGlossaryChoices() map[string]string {
+	path, err := glossariesCachePath()
+	if err != nil {
+		return nil
+	}
+	cache, err := readGlossariesCache(path)
+	if err != nil || cache == nil {
+		return nil
+	}
+	choices := make(map[string]string, len(cache.Glossaries))
+	for _, g := range cache.Glossaries {
+		choices[g.Name] = strings.ToUpper(g.SourceLang) + " ⇒ " + strings.ToUpper(g.TargetLang)
+	}
+	return choices
+}
+
+// Writes the candidates one per line, as "value<TAB>description" (or just the value).
+func printCompletions(w io.Writer, completions []completion) {
+	for _, c := range completions {
+		if c.Description != "" {
+			fmt.Fprintf(w, "%s\t%s\n", c.Value, c.Description)
+		} else {
+			fmt.Fprintln(w, c.Value)
+		}
+	}
+}
+
+// Completion scripts, by shell; %[1]s is the program name, %[2]s the same as a function name.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for %[1]s; generated by ` + "`%[1]s completion bash`" + `.
+_%[2]s_complete() {
+	local IFS=$'\n'
+	local candidates
+	candidates=$("${COMP_WORDS[0]}" __complete "${COMP_WORDS[@]:0:$((COMP_CWORD + 1))}" 2>/dev/null | cut -f1)
+	# already filtered, ignoring case (e.g. "en" offers "EN-GB").
+	COMPREPLY=(${candidates})
+}
+complete -o default -F _%[2]s_complete %[1]s
+`,
+	"zsh": `#compdef %[1]s
+# zsh completion for %[1]s; generated by ` + "`%[1]s completion zsh`" + `.
+_%[2]s() {
+	local -a candidates
+	local line value
+	for line in "${(@f)$("${words[1]}" __complete "${(@)words[1,CURRENT]}" 2>/dev/null)}"; do
+		[[ -n $line ]] || continue
+		value=${line%%$'\t'*}
+		value=${value//:/\\:}
+		if [[ $line == *$'\t'* ]]; then
+			candidates+=("$value:${line#*$'\t'}")
+		else
+			candidates+=("$value")
+		fi
+	done
+	if (( ${#candidates} )); then
+		_describe 'values' candidates
+	else
+		_files
+	fi
+}
+compdef _%[2]s %[1]s
+`,
+	"fish": `# fish completion for %[1]s; generated by ` + "`%[1]s completion fish`" + `.
+function __%[2]s_complete
+	set -l words (commandline -opc) (commandline -ct)
+	$words[1] __complete $words 2>/dev/null
+end
+# file names are completed only when there is nothing else.
+complete -c %[1]s -f -n 'count (__%[2]s_complete) >/dev/null' -a '(__%[2]s_complete)'
+`,
+	"powershell": `# PowerShell completion for %[1]s; generated by ` + "`%[1]s completion powershell`" + `.
+Register-ArgumentCompleter -Native -CommandName '%[1]s' -ScriptBlock {
+	param($wordToComplete, $commandAst, $cursorPosition)
+	$words = @($commandAst.CommandElements | Where-Object { $_.Extent.StartOffset -lt $cursorPosition } | ForEach-Object { $_.ToString() })
+	if ($wordToComplete -eq '') {
+		# Windows PowerShell drops empty arguments.
+		$words += '""'
+	}
+	& $words[0] __complete @words 2>$null | ForEach-Object {
+		$value, $description = $_ -split "` + "`" + `t", 2
+		if (-not $description) { $description = $value }
+		[System.Management.Automation.CompletionResult]::new($value, $value, 'ParameterValue', $description)
+	}
+}
+`,
+}
+
+// Writes the completion script for shell.
+func writeCompletionScript(w io.Writer, program string, shell string) error {
+	script, ok := completionScripts[shell]
+	if !ok {
+		shells := append([]string(nil), completionShells...)
+		sort.Strings(shells)
+		return fmt.Errorf("no completion for %q; the shell must be one of %s", shell, strings.Join(shells, ", "))
+	}
+	_, err := fmt.Fprintf(w, script, program, strings.NewReplacer("-", "_", ".", "_").Replace(program))
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+	"github.com/urfave/cli/v2"
+)
+
+func TestSettingChoices(t *testing.T) {
+	for key, choices := range settingChoices {
+		for value := range choices {
+			if err := validateSetting(key, value); err != nil {
+				t.Errorf("%s offers %q, which is not valid: %s", key, value, err)
+			}
+		}
+	}
+}
+
+func TestCompleteWords(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	path, err := languagesCachePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeLanguagesCache(path, testLanguages); err != nil {
+		t.Fatal(err)
+	}
+	if path, err = glossariesCachePath(); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCacheFile(path, glossaryCache{Glossaries: []deepl.Glossary{{Name: "product-terms", SourceLang: "en", TargetLang: "de"}, {Name: "campaign"}}}); err != nil {
+		t.Fatal(err)
+	}
+	app := &cli.App{
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "target_lang", Aliases: []string{"t"}},
+			&cli.StringFlag{Name: "profile", Aliases: []string{"P"}},
+			&cli.BoolFlag{Name: "debug", Aliases: []string{"d"}},
+		},
+		Commands: []*cli.Command{
+			{
+				Name:		"translate",
+				Aliases:	[]string{"trans"},
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "tag_handling", Aliases: []string{"tag"}, Usage: "Set to XML or HTML\nand more"},
+					&cli.StringFlag{Name: "formality"},
+					&cli.StringFlag{Name: "glossary"},
+				},
+			},
+			{
+				Name:		"config",
+				Subcommands: []*cli.Command{{Name: "set"}, {Name: "show"}},
+			},
+			{Name: "completion"},
+			{Name: completeCommandName, Hidden: true},
+		},
+	}
+	setting := defaultSettings()
+	setting.Profiles = map[string]json.RawMessage{"work": nil, "home": nil}
+	// completion must never go looking for the token.
+	marker := filepath.Join(t.TempDir(), "token-command-ran")
+	setting.TokenCommand = "touch " + marker
+
+	tests := []struct {
+		words		string
+		expected	string
+	}{
+		{"c|", "config completion"},
+		{"-t|", "-t"},
+		{"-d -t p|", "PT-BR PT-PT"},
+		{"-t=e|", "-t=EN-GB -t=EN-US"},
+		{"-t DE,ja,p|", "DE,ja,PT-BR DE,ja,PT-PT"},
+		{"--profile |", "home work"},
+		{"trans --t|", "--tag_handling --tag"},
+		{"translate --tag |", "html xml"},
+		{"translate --tag = h|", "html"},
+		{"translate --formality prefer|", "prefer_less prefer_more"},
+		{"translate --glossary p|", "product-terms"},
+		{"translate file.txt |", ""},
+		{"config |", "set show"},
+		{"config set tag|", "tag_handling"},
+		{"config set segment |", "none paragraph sentence"},
+		{"completion f|", "fish"},
+		{`completion ""|`, "bash fish powershell zsh"},
+	}
+	for _, test := range tests {
+		words := strings.Split(strings.TrimSuffix(test.words, "|"), " ")
+		var actual []string
+		for _, c := range completeWords(app, setting, words) {
+			actual = append(actual, c.Value)
+		}
+		if strings.Join(actual, " ") != test.expected {
+			t.Errorf("%q\nExpected: %s\nActual: %s", test.words, test.expected, strings.Join(actual, " "))
+		}
+	}
+
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("Completion ran the token command")
+	}
+
+	var b bytes.Buffer
+	printCompletions(&b, completeWords(app, setting, []string{"trans", "--tag_"}))
+	if expected := "--tag_handling\tSet to XML or HTML\n"; b.String() != expected {
+		t.Errorf("Expected: %q\nActual: %q", expected, b.String())
+	}
+}
+
+func TestWriteCompletionScript(t *testing.T) {
+	for _, shell := range completionShells {
+		var b bytes.Buffer
+		if err := writeCompletionScript(&b, "deepl-translate-cli", shell); err != nil {
+			t.Errorf("%s: unexpected error: %s", shell, err)
+		}
+		if !strings.Contains(b.String(), completeCommandName) || strings.Contains(b.String(), "%!") {
+			t.Errorf("%s: unexpected script:\n%s", shell, b.String())
+		}
+	}
+	if err := writeCompletionScript(&bytes.Buffer{}, "deepl-translate-cli", "tcsh"); err == nil {
+		t.Errorf("Expected an error for an unknown shell")
+	}
+}
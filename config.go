@@ -0,0 +1,626 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Name of the project-level settings file, searched from the working directory upwards.
+const projectConfigName = ".deepl.json"
+
+// Name of the user-level settings file, inside configDir().
+const userConfigName = "setting.json"
+
+// Origins of the settings that were not set anywhere else.
+const originDefault = "default"
+
+// Returns the directory where settings (and other persistent data) are stored,
+// honouring XDG_CONFIG_HOME (which must be an absolute path, as per the spec).
+func configDir() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" && filepath.IsAbs(dir) {
+		return filepath.Join(dir, "deepl-translate-cli"), nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".config", "deepl-translate-cli"), nil
+}
+
+// Returns the path of the user-level settings file.
+func userConfigPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, userConfigName), nil
+}
+
+// Walks up from dir looking for a project-level settings file; returns "" if there is none.
+func findProjectConfig(dir string) string {
+	for dir != "" {
+		path := filepath.Join(dir, projectConfigName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return ""
+}
+
+// Values set by the defaults, before any settings file, variable or flag is looked at.
+func defaultSettings() Setting {
+	return Setting{
+		SourceLang:			"EN",
+		TargetLang:			"JA",
+		PreserveFormatting:	"0",
+		FuzzyThreshold:		defaultFuzzyThreshold,
+		PreserveWhitespace:	true,
+		SegmentBy:			segmentBySentence,
+		LanguagesTTL:		defaultLanguagesTTL.String(),
+		TargetVariants:		map[string]string{"EN": "EN-US", "PT": "PT-PT"},
+	}
+}
+
+// Maps each setting (by its JSON name) to a description of where its value came from,
+// e.g. "flag --target_lang" or "project file /some/where/.deepl.json".
+type settingOrigins map[string]string
+
+// A source of settings that are given as strings, i.e. environment variables or flags.
+type settingLayer struct {
+	values	map[string]string	// Values, by JSON name of the setting.
+	origins	map[string]string	// Where each value came from.
+}
+
+func newSettingLayer() settingLayer {
+	return settingLayer{values: make(map[string]string), origins: make(map[string]string)}
+}
+
+// Prefix of the environment variables that override settings.
+const envPrefix = "DEEPL_"
+
+// Returns the environment variable that overrides a setting: e.g. DEEPL_TARGET_LANG for target_lang.
+// Settings that are not strings, booleans or numbers (e.g. DEEPL_BUDGET) are given as JSON.
+func envSettingName(key string) string {
+	return envPrefix + strings.ToUpper(key)
+}
+
+// Environment variables that are not settings, but are still understood.
+var envOther = map[string]string{
+	"DEEPL_TOKEN":		"API token, unless another token source is set",
+	"DEEPL_DRY_RUN":	"same as `translate --dry-run`",
+}
+
+// Collects the settings given as DEEPL_* environment variables.
+func envLayer() settingLayer {
+	layer := newSettingLayer()
+	for _, key := range settingKeys() {
+		name := envSettingName(key)
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			layer.values[key] = v
+			layer.origins[key] = "environment " + name
+		}
+	}
+	return layer
+}
+
+// One DEEPL_* environment variable that is set.
+type activeEnv struct {
+	Name	string	`json:"name"`
+	Value	string	`json:"value"`
+	Use		string	`json:"use"`	// What it does; empty if it is not known.
+}
+
+// Lists the DEEPL_* environment variables that are set, sorted, and what each one is for;
+// tokens are masked. Variables named by token_env settings are known, too.
+func activeEnvironment(setting Setting) []activeEnv {
+	known := make(map[string]string)
+	for _, key := range settingKeys() {
+		known[envSettingName(key)] = "setting " + key
+	}
+	for name, use := range envOther {
+		known[name] = use
+	}
+	tokens := map[string]bool{"DEEPL_TOKEN": true}
+	tokenEnvs := []string{setting.TokenEnv}
+	for _, key := range setting.Keys {
+		tokenEnvs = append(tokenEnvs, key.TokenEnv)
+	}
+	for _, raw := range setting.Profiles {
+		var profile struct {
+			TokenEnv	string	`json:"token_env"`
+		}
+		if json.Unmarshal(raw, &profile) == nil {
+			tokenEnvs = append(tokenEnvs, profile.TokenEnv)
+		}
+	}
+	for _, name := range tokenEnvs {
+		if name != "" {
+			known[name], tokens[name] = "API token (token_env)", true
+		}
+	}
+
+	var active []activeEnv
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(name, envPrefix) && known[name] == "" {
+			continue
+		}
+		if tokens[name] && len(value) > 4 {
+			value = "…" + value[len(value)-4:]
+		}
+		active = append(active, activeEnv{Name: name, Value: value, Use: known[name]})
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].Name < active[j].Name
+	})
+	return active
+}
+
+// Global flags that override settings, by flag name.
+var globalFlagSettings = map[string]string{
+	"source_lang":	"source_lang",
+	"target_lang":	"target_lang",
+	"pro":			"pro",
+	"free":			"free",
+	"project":		"project",
+	"profile":		"profile",
+	"debug":		"debug",
+}
+
+// Translate flags that override settings, by flag name.
+var translateFlagSettings = map[string]string{
+	"tag_handling":			"tag_handling",
+	"split_sentences":		"split_sentences",
+	"preserve_formatting":	"preserve_formatting",
+	"outline_detection":	"outline_detection",
+	"non_splitting_tags":	"non_splitting_tags",
+	"splitting_tags":		"splitting_tags",
+	"ignore_tags":			"ignore_tags",
+	"formality":			"formality",
+	"glossary":				"glossary",
+	"memory":				"memory",
+	"segment":				"segment",
+	"fuzzy-threshold":		"fuzzy_threshold",
+	"use-fuzzy":			"use_fuzzy",
+	"preserve-whitespace":	"preserve_whitespace",
+}
+
+// Checks the settings whose values are restricted, by JSON name; empty values are
+// always accepted, and mean that DeepL (or this tool) picks its own default.
+var settingValidators = map[string]func(v string) error{
+	"type": func(v string) error {
+		switch v {
+			case "", "source", "target", "all":
+				return nil
+			default:
+				return fmt.Errorf("type must be either `source`, `target` or `all` (got: %s)", v)
+		}
+	},
+	"tag_handling": func(v string) error {
+		switch v {
+			case "", "xml", "html":
+				return nil
+			default:
+				return fmt.Errorf("tag_handling must be either `xml` or `html` (got: %s)", v)
+		}
+	},
+	"split_sentences": func(v string) error {
+		switch v {
+			case "", "0", "1", "nonewlines":
+				return nil
+			default:
+				return fmt.Errorf("split_sentences can only be 0, 1, or `nonewlines` (got: %s)", v)
+		}
+	},
+	"preserve_formatting": func(v string) error {
+		switch v {
+			case "", "0", "1":
+				return nil
+			default:
+				return fmt.Errorf("preserve_formatting can only be 0 or 1 (got: %s)", v)
+		}
+	},
+	"formality": func(v string) error {
+		switch v {
+			case "", "default", "more", "less", "prefer_more", "prefer_less":
+				return nil
+			default:
+				return fmt.Errorf("formality must be one of `default`, `more`, `less`, `prefer_more` or `prefer_less` (got: %s)", v)
+		}
+	},
+	"segment": func(v string) error {
+		switch v {
+			case "", segmentBySentence, segmentByParagraph, segmentByNone:
+				return nil
+			default:
+				return fmt.Errorf("segment must be either `sentence`, `paragraph` or `none` (got: %s)", v)
+		}
+	},
+	"languages_ttl": func(v string) error {
+		if d, err := time.ParseDuration(v); v != "" && (err != nil || d < 0) {
+			return fmt.Errorf("languages_ttl must be a duration such as `24h` or `30m` (got: %s)", v)
+		}
+		return nil
+	},
+	"fuzzy_threshold": func(v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 || f > 1 {
+			return fmt.Errorf("fuzzy_threshold must be greater than 0 and at most 1 (got: %s)", v)
+		}
+		return nil
+	},
+}
+
+// Checks the value of a setting (by its JSON name), if its values are restricted.
+func validateSetting(key string, value string) error {
+	if validate, ok := settingValidators[key]; ok {
+		return validate(value)
+	}
+	return nil
+}
+
+// Returns a flag action checking its value as the given setting.
+func validateFlag(key string) func(c *cli.Context, v string) error {
+	return func(c *cli.Context, v string) error {
+		return validateSetting(key, v)
+	}
+}
+
+// Checks all restricted settings, e.g. after reading them from files.
+func (s Setting) validate() error {
+	for _, key := range settingKeys() {
+		if _, ok := settingValidators[key]; !ok {
+			continue
+		}
+		value, err := settingValue(s, key)
+		if err != nil {
+			return err
+		}
+		if err := validateSetting(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Collects the settings given as flags, but only those that were actually set on the command line.
+func flagLayer(c *cli.Context, flags map[string]string) settingLayer {
+	layer := newSettingLayer()
+	for name, key := range flags {
+		if !c.IsSet(name) {
+			continue
+		}
+		if name == "debug" {
+			// a counter, not a boolean.
+			layer.values[key] = strconv.Itoa(debugLevel)
+		} else {
+			layer.values[key] = fmt.Sprint(c.Value(name))
+		}
+		layer.origins[key] = "flag --" + name
+	}
+	return layer
+}
+
+// Builds the effective settings, each source overriding the previous one: the defaults,
+// the user settings file, the project settings file found from workDir upwards, the
+// selected profile, and then the given layers (usually, the environment and the
+// command-line flags). The profile is selected by the last layer that sets "profile",
+// or else by the settings files.
+// None of the settings files needs to exist; see `config init`.
+func LoadSettings(workDir string, layers ...settingLayer) (Setting, settingOrigins, error) {
+	setting := defaultSettings()
+	origins := make(settingOrigins)
+	for _, key := range settingKeys() {
+		origins[key] = originDefault
+	}
+
+	configPath, err := userConfigPath()
+	if err != nil {
+		return setting, origins, err
+	}
+	if _, err := applySettingsFile(&setting, origins, configPath, "user file", true); err != nil {
+		return setting, origins, err
+	}
+	if projectPath := findProjectConfig(workDir); projectPath != "" {
+		if _, err := applySettingsFile(&setting, origins, projectPath, "project file", false); err != nil {
+			return setting, origins, err
+		}
+	}
+
+	profile := setting.Profile
+	for _, layer := range layers {
+		if name, ok := layer.values["profile"]; ok {
+			profile = name
+		}
+	}
+	if profile != "" {
+		if err := applyProfile(&setting, origins, profile); err != nil {
+			return setting, origins, err
+		}
+	}
+	if err := applySettingLayers(&setting, origins, layers...); err != nil {
+		return setting, origins, err
+	}
+
+	if setting.SourceLang == "FILLIN" || setting.TargetLang == "FILLIN" {
+		// left behind by earlier versions, which created the file with placeholders.
+		return setting, origins, fmt.Errorf("the settings file still has FILLIN placeholders; run `deepl-translate-cli config init --force`, or edit %s", configPath)
+	}
+	if setting.IsPro && setting.IsFree {
+		return setting, origins, fmt.Errorf("cannot use both the Pro (%s) and the Free (%s) endpoints", origins["pro"], origins["free"])
+	}
+	if setting.SourceLang == setting.TargetLang {
+		return setting, origins, fmt.Errorf("cannot have identical source lang(%s) and target lang(%s)", setting.SourceLang, setting.TargetLang)
+	}
+	return setting, origins, setting.validate()
+}
+
+// Applies the layers on top of setting, in order, recording where each value came from.
+func applySettingLayers(setting *Setting, origins settingOrigins, layers ...settingLayer) error {
+	for _, layer := range layers {
+		for key, value := range layer.values {
+			if err := setSettingField(setting, key, value); err != nil {
+				return fmt.Errorf("%s (occurred while reading %s)", err, layer.origins[key])
+			}
+			if err := validateSetting(key, value); err != nil {
+				return fmt.Errorf("%s (occurred while reading %s)", err, layer.origins[key])
+			}
+			origins[key] = layer.origins[key]
+		}
+	}
+	return nil
+}
+
+// Reads a settings file on top of setting, recording the origin of every value it sets.
+// Untrusted files may not say where the API token comes from, nor which files are used
+// (see tokenSourceKeys and filePathKeys).
+// Returns false if the file does not exist.
+func applySettingsFile(setting *Setting, origins settingOrigins, path string, label string, trusted bool) (bool, error) {
+	bytes, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(bytes, &keys); err != nil {
+		return true, settingsFileError(path, bytes, err)
+	}
+	if !trusted {
+		if err := checkProjectKeys(keys); err != nil {
+			return true, fmt.Errorf("%s (occurred while reading %s)", err, path)
+		}
+	}
+	if err := json.Unmarshal(bytes, setting); err != nil {
+		return true, settingsFileError(path, bytes, err)
+	}
+	for key := range keys {
+		if _, ok := origins[key]; ok {
+			origins[key] = label + " " + path
+		}
+	}
+	return true, nil
+}
+
+// Settings that say where the API token comes from. The project file is picked up from
+// wherever the command runs (e.g. inside a freshly cloned repository), so it must not get
+// to run commands, nor to read files or variables, in order to find a token.
+var tokenSourceKeys = []string{"token_env", "token_file", "token_command", "keys"}
+
+// Settings that say which files are read and written. For the same reason, the project file
+// must not get to point them at files elsewhere.
+var filePathKeys = []string{"memory_path", "ledger_path", "history_path"}
+
+// Returns an error if the settings, or any of their profiles, set a token source or a file path.
+func checkProjectKeys(keys map[string]json.RawMessage) error {
+	groups := []struct {
+		keys	[]string
+		hint	string
+	}{
+		{tokenSourceKeys, "token sources can only be set in the user settings file, in environment variables or with flags"},
+		{filePathKeys, "file locations can only be set in the user settings file or in environment variables"},
+	}
+	for _, group := range groups {
+		for _, key := range group.keys {
+			if _, ok := keys[key]; ok {
+				return fmt.Errorf("%s cannot be set in a project file; %s", key, group.hint)
+			}
+		}
+	}
+	var profiles map[string]map[string]json.RawMessage
+	if raw, ok := keys["profiles"]; ok && json.Unmarshal(raw, &profiles) == nil {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, group := range groups {
+				for _, key := range group.keys {
+					if _, ok := profiles[name][key]; ok {
+						return fmt.Errorf("profiles.%s.%s cannot be set in a project file; %s", name, key, group.hint)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the JSON names of all settings that can be stored in a file, in declaration order.
+func settingKeys() []string {
+	var keys []string
+	t := reflect.TypeOf(Setting{})
+	for i := 0; i < t.NumField(); i++ {
+		if key := jsonName(t.Field(i)); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Returns the JSON name of a struct field, or "" if it is not serialised.
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" || !f.IsExported() {
+		return ""
+	}
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// Returns the field of setting with the given JSON name.
+func settingField(setting *Setting, key string) (reflect.Value, error) {
+	v := reflect.ValueOf(setting).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if jsonName(t.Field(i)) == key {
+			return v.Field(i), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("unknown setting %q", key)
+}
+
+// Sets a setting (by its JSON name) from its string representation.
+// Settings that are not strings, booleans or numbers are given as JSON.
+func setSettingField(setting *Setting, key string, value string) error {
+	field, err := settingField(setting, key)
+	if err != nil {
+		return err
+	}
+	switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s must be true or false (got: %s)", key, value)
+			}
+			field.SetBool(b)
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s must be an integer (got: %s)", key, value)
+			}
+			field.SetInt(int64(n))
+		case reflect.Float64:
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("%s must be a number (got: %s)", key, value)
+			}
+			field.SetFloat(f)
+		default:
+			if err := json.Unmarshal([]byte(value), field.Addr().Interface()); err != nil {
+				return fmt.Errorf("%s must be valid JSON (%s)", key, err)
+			}
+	}
+	return nil
+}
+
+// Returns the value of a setting (by its JSON name) as a string, JSON-encoding anything
+// that is not a string, a boolean or a number.
+func settingValue(setting Setting, key string) (string, error) {
+	field, err := settingField(&setting, key)
+	if err != nil {
+		return "", err
+	}
+	switch field.Kind() {
+		case reflect.String, reflect.Bool, reflect.Int, reflect.Float64:
+			return fmt.Sprint(field.Interface()), nil
+		default:
+			b, err := json.Marshal(field.Interface())
+			return string(b), err
+	}
+}
+
+// Creates a settings file with nothing but the default language pair, along with its directory;
+// used by `config init` when there is nobody to ask.
+func InitializeConfigFile(ConfigPath string) error {
+	defaults := defaultSettings()
+	values := make(map[string]json.RawMessage)
+	for key, value := range map[string]string{"source_lang": defaults.SourceLang, "target_lang": defaults.TargetLang} {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		values[key] = raw
+	}
+	return writeSettingsFile(ConfigPath, values)
+}
+
+// Writes the effective settings, optionally with where each one came from.
+func showSettings(w io.Writer, setting Setting, origins settingOrigins, withOrigin bool, output string) error {
+	type shownSetting struct {
+		Value	string	`json:"value"`
+		Origin	string	`json:"origin,omitempty"`
+	}
+	keys := settingKeys()
+	shown := make(map[string]shownSetting, len(keys))
+	for _, key := range keys {
+		value, err := settingValue(setting, key)
+		if err != nil {
+			return err
+		}
+		s := shownSetting{Value: value}
+		if withOrigin {
+			s.Origin = origins[key]
+		}
+		shown[key] = s
+	}
+
+	if output == outputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(shown)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, key := range keys {
+		if withOrigin {
+			fmt.Fprintf(tw, "%s\t%s\t(%s)\n", key, shown[key].Value, shown[key].Origin)
+		} else {
+			fmt.Fprintf(tw, "%s\t%s\n", key, shown[key].Value)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return showEnvironment(w, activeEnvironment(setting))
+}
+
+// Writes the active environment variables, if any, flagging those that are not understood (e.g. typos).
+func showEnvironment(w io.Writer, active []activeEnv) error {
+	if len(active) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "\nEnvironment variables:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, env := range active {
+		use := env.Use
+		if use == "" {
+			use = "unknown; ignored"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t(%s)\n", env.Name, env.Value, use)
+	}
+	return tw.Flush()
+}
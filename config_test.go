@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+)
+
+func TestCheckSettingsData(t *testing.T) {
+	tests := []struct {
+		name		string
+		data		string
+		expected	[]string
+	}{
+		{"valid", "{\n  \"source_lang\": \"EN\",\n  \"budget\": {\"per_day\": 100}\n}\n", nil},
+		{"syntax error", "{\n  \"source_lang\": \"EN\"\n  \"target_lang\": \"DE\"\n}\n", []string{"3:3: invalid character '\"' after object key:value pair"}},
+		{"unknown keys", "{\n  \"target_lamg\": \"DE\",\n  \"budget\": {\"per_week\": 1}\n}", []string{
+			`2:3: unknown setting "target_lamg"; did you mean "target_lang"?`,
+			`3:14: unknown setting "budget.per_week"`,
+		}},
+		{"wrong type", `{"pro": "yes", "budget": {"per_day": "lots"}}`, []string{
+			"1:9: pro must be true or false, not string",
+			"1:38: budget.per_day must be a number, not string",
+		}},
+		{"invalid value", `{"formality": "very"}`, []string{"1:15: formality must be one of `default`, `more`, `less`, `prefer_more` or `prefer_less` (got: very)"}},
+		{"profiles", `{"profiles": {"work": {"target_lang": "DE", "profile": "x", "glosary": "g"}}}`, []string{
+			`1:45: profile "work" cannot set "profile"`,
+			`1:61: unknown setting "glosary"; did you mean "glossary"?`,
+		}},
+		{"not an object", `["EN"]`, []string{"1:1: settings must be a JSON object"}},
+	}
+	for _, test := range tests {
+		problems := checkSettingsData([]byte(test.data))
+		var actual []string
+		for _, p := range problems {
+			actual = append(actual, p.String())
+		}
+		if strings.Join(actual, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("%s\nExpected: %q\nActual: %q", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestSettingPaths(t *testing.T) {
+	values := make(map[string]json.RawMessage)
+	for _, set := range []struct{ key, value string }{
+		{"target_lang", "DE"},
+		{"pro", "true"},
+		{"budget.per_day", "1000"},
+		{"profiles.work.target_lang", "FR"},
+	} {
+		raw, err := settingJSON(set.key, set.value)
+		if err != nil {
+			t.Fatalf("Unexpected error setting %s: %s", set.key, err)
+		}
+		path := splitSettingKey(set.key)
+		if err := setSettingPath(values, path, raw); err != nil {
+			t.Fatalf("Unexpected error setting %s: %s", set.key, err)
+		}
+		if err := checkSetting(path[0], values[path[0]]); err != nil {
+			t.Fatalf("Unexpected error checking %s: %s", set.key, err)
+		}
+	}
+	data, _ := json.Marshal(values)
+	expected := `{"budget":{"per_day":1000},"pro":true,"profiles":{"work":{"target_lang":"FR"}},"target_lang":"DE"}`
+	if string(data) != expected {
+		t.Errorf("Expected: %s\nActual: %s", expected, data)
+	}
+	if raw, ok := getSettingPath(values, splitSettingKey("profiles.work.target_lang")); !ok || formatSettingJSON(raw) != "FR" {
+		t.Errorf("Expected to get FR, got %s", raw)
+	}
+
+	if _, err := settingJSON("pro", "maybe"); err == nil {
+		t.Errorf("Expected an error for an invalid boolean")
+	}
+	if _, err := settingJSON("target_lamg", "DE"); err == nil {
+		t.Errorf("Expected an error for an unknown setting")
+	}
+	if err := checkSetting("budget", json.RawMessage(`{"per_week": 1}`)); err == nil {
+		t.Errorf("Expected an error for an unknown nested setting")
+	}
+
+	if !unsetSettingPath(values, splitSettingKey("profiles.work.target_lang")) || !unsetSettingPath(values, splitSettingKey("pro")) {
+		t.Fatalf("Expected the settings to be removed")
+	}
+	if unsetSettingPath(values, splitSettingKey("pro")) {
+		t.Errorf("Expected nothing to be removed the second time")
+	}
+	data, _ = json.Marshal(values)
+	expected = `{"budget":{"per_day":1000},"target_lang":"DE"}`
+	if string(data) != expected {
+		t.Errorf("Expected: %s\nActual: %s", expected, data)
+	}
+}
+
+func TestAskSettings(t *testing.T) {
+	sources := []deepl.DeepLLanguagesResponse{{Language: "EN", Name: "English"}, {Language: "DE", Name: "German"}}
+	targets := []deepl.DeepLLanguagesResponse{{Language: "EN-GB", Name: "English (British)"}, {Language: "DE", Name: "German", SupportsFormality: true}}
+	var out bytes.Buffer
+	// "xx" is not a target language, and gets asked again.
+	in := strings.NewReader("de\nxx\nen-gb\n\n")
+	values, err := askSettings(askLines(in, &out), &out, sources, targets)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	data, _ := json.Marshal(values)
+	expected := `{"source_lang":"DE","target_lang":"EN-GB"}`
+	if string(data) != expected {
+		t.Errorf("Expected: %s\nActual: %s\nOutput: %s", expected, data, out.String())
+	}
+	if strings.Contains(out.String(), "Formality") {
+		t.Errorf("Formality should not be asked for a target language without it\nOutput: %s", out.String())
+	}
+}
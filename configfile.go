@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Flags shared by the `config` subcommands that work on one settings file.
+func configFileFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "local",
+			Usage: "Use the project settings file (the nearest " + projectConfigName + ", or a new one in the working directory) instead of the user one",
+		},
+		&cli.StringFlag{
+			Name:  "file",
+			Usage: "Use the settings file at `PATH`",
+		},
+	}
+}
+
+// Returns the settings file selected with --file or --local, or else the user settings file.
+func configFilePath(c *cli.Context) (string, error) {
+	if path := c.String("file"); path != "" {
+		return path, nil
+	}
+	if c.Bool("local") {
+		workDir, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		if path := findProjectConfig(workDir); path != "" {
+			return path, nil
+		}
+		return filepath.Join(workDir, projectConfigName), nil
+	}
+	return userConfigPath()
+}
+
+// Returns the settings files that exist, the user one first; or just the one selected
+// with --file or --local, whether or not it exists.
+func configFilePaths(c *cli.Context) ([]string, error) {
+	if c.IsSet("file") || c.Bool("local") {
+		path, err := configFilePath(c)
+		if err != nil {
+			return nil, err
+		}
+		return []string{path}, nil
+	}
+	var paths []string
+	userPath, err := userConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(userPath); err == nil {
+		paths = append(paths, userPath)
+	}
+	workDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if projectPath := findProjectConfig(workDir); projectPath != "" {
+		paths = append(paths, projectPath)
+	}
+	return paths, nil
+}
+
+// Reads a settings file as raw JSON values, by key; a missing file has no values.
+func readSettingsFile(path string) (map[string]json.RawMessage, error) {
+	values := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return values, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, settingsFileError(path, data, err)
+	}
+	return values, nil
+}
+
+// Turns an error from parsing a settings file into one that says where the problem is.
+func settingsFileError(path string, data []byte, err error) error {
+	if problems := checkSettingsData(data); len(problems) > 0 {
+		return fmt.Errorf("%s:%s (run `deepl-translate-cli config validate` for details)", path, problems[0])
+	}
+	return fmt.Errorf("%s (occurred while loading %s)", err, path)
+}
+
+// Checks the value of a single setting, e.g. before it is written by `config set`.
+func checkSetting(key string, raw json.RawMessage) error {
+	data, err := json.Marshal(map[string]json.RawMessage{key: raw})
+	if err != nil {
+		return err
+	}
+	if problems := checkSettingsData(data); len(problems) > 0 {
+		return errors.New(problems[0].Message)
+	}
+	return nil
+}
+
+// Writes a settings file, replacing whatever was there.
+func writeSettingsFile(path string, values map[string]json.RawMessage) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first, so that an interrupted run never leaves a truncated file behind.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(out, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write to config file: %s", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Splits a key such as "budget.per_day" into its path.
+func splitSettingKey(key string) []string {
+	return strings.Split(key, ".")
+}
+
+// Returns the raw value at path, if there is one.
+func getSettingPath(values map[string]json.RawMessage, path []string) (json.RawMessage, bool) {
+	raw, ok := values[path[0]]
+	if !ok || len(path) == 1 {
+		return raw, ok
+	}
+	var inner map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &inner); err != nil {
+		return nil, false
+	}
+	return getSettingPath(inner, path[1:])
+}
+
+// Sets the raw value at path, creating (or replacing) the objects on the way.
+func setSettingPath(values map[string]json.RawMessage, path []string, value json.RawMessage) error {
+	if len(path) == 1 {
+		values[path[0]] = value
+		return nil
+	}
+	inner := make(map[string]json.RawMessage)
+	if raw, ok := values[path[0]]; ok {
+		if err := json.Unmarshal(raw, &inner); err != nil {
+			return fmt.Errorf("%s is not an object", path[0])
+		}
+	}
+	if err := setSettingPath(inner, path[1:], value); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(inner)
+	if err != nil {
+		return err
+	}
+	values[path[0]] = raw
+	return nil
+}
+
+// Removes the value at path; returns false if there was none. Objects left empty are removed as well.
+func unsetSettingPath(values map[string]json.RawMessage, path []string) bool {
+	raw, ok := values[path[0]]
+	if !ok {
+		return false
+	}
+	if len(path) == 1 {
+		delete(values, path[0])
+		return true
+	}
+	var inner map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &inner); err != nil || !unsetSettingPath(inner, path[1:]) {
+		return false
+	}
+	if len(inner) == 0 {
+		delete(values, path[0])
+		return true
+	}
+	raw, err := json.Marshal(inner)
+	if err != nil {
+		return false
+	}
+	values[path[0]] = raw
+	return true
+}
+
+// Converts the value given to `config set` into JSON. Settings that are strings, booleans or
+// numbers are parsed as such; anything else (including keys inside objects) must be valid JSON,
+// or is taken as a string.
+func settingJSON(key string, value string) (json.RawMessage, error) {
+	path := splitSettingKey(key)
+	var setting Setting
+	field, err := settingField(&setting, path[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(path) == 1 && field.Kind() != reflect.Map && field.Kind() != reflect.Struct {
+		if err := setSettingField(&setting, path[0], value); err != nil {
+			return nil, err
+		}
+		if err := validateSetting(path[0], value); err != nil {
+			return nil, err
+		}
+		return json.Marshal(field.Interface())
+	}
+	if json.Valid([]byte(value)) {
+		return json.RawMessage(value), nil
+	}
+	return json.Marshal(value)
+}
+
+// Writes a raw value for humans: strings without quotes, anything else as JSON.
+func formatSettingJSON(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return string(raw)
+	}
+	return compact.String()
+}
+
+// Returns the effective value of a setting, given as a key such as "budget.per_day".
+func effectiveSetting(setting Setting, key string) (json.RawMessage, error) {
+	data, err := json.Marshal(setting)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	raw, ok := getSettingPath(values, splitSettingKey(key))
+	if !ok {
+		return nil, fmt.Errorf("unknown setting %q", key)
+	}
+	return raw, nil
+}
+
+// Lists the values set in each of the settings files.
+func listSettingsFiles(w io.Writer, paths []string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, path := range paths {
+		values, err := readSettingsFile(path)
+		if err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(tw, "%s\t%s\t(%s)\n", key, formatSettingJSON(values[key]), path)
+		}
+	}
+	return tw.Flush()
+}
+
+// A problem found in a settings file, at a given position.
+type configProblem struct {
+	Line	int
+	Column	int
+	Message	string
+}
+
+func (p configProblem) String() string {
+	return fmt.Sprintf("%d:%d: %s", p.Line, p.Column, p.Message)
+}
+
+// Returns the line and column (both starting at 1, the column counted in runes) of offset in data.
+func positionAt(data []byte, offset int) (int, int) {
+	offset = min(max(offset, 0), len(data))
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	lineStart := bytes.LastIndexByte(data[:offset], '\n') + 1
+	return line, 1 + utf8.RuneCount(data[lineStart:offset])
+}
+
+// One member of a JSON object, with the offsets of its key and its value.
+type jsonMember struct {
+	Key			string
+	KeyOffset	int
+	Value		json.RawMessage
+	ValueOffset	int
+}
+
+// Returns the members of the JSON object raw, which starts at offset base of the whole document.
+func objectMembers(raw []byte, base int) ([]jsonMember, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, fmt.Errorf("not an object")
+	}
+	var members []jsonMember
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		keyEnd := int(decoder.InputOffset())
+		member := jsonMember{
+			Key:		token.(string),
+			KeyOffset:	base + bytes.LastIndexByte(raw[:keyEnd-1], '"'),
+		}
+		if err := decoder.Decode(&member.Value); err != nil {
+			return nil, err
+		}
+		member.ValueOffset = base + int(decoder.InputOffset()) - len(member.Value)
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// Checks a settings file: JSON syntax, unknown keys (also inside objects and profiles),
+// values of the wrong type and values that are not allowed. Problems are in document order.
+func checkSettingsData(data []byte) []configProblem {
+	problem := func(offset int, format string, args ...any) configProblem {
+		line, column := positionAt(data, offset)
+		return configProblem{Line: line, Column: column, Message: fmt.Sprintf(format, args...)}
+	}
+	var document any
+	if err := json.Unmarshal(data, &document); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			// the offset is just past the offending character.
+			return []configProblem{problem(int(syntaxErr.Offset)-1, "%s", syntaxErr)}
+		}
+		return []configProblem{problem(0, "%s", err)}
+	}
+	start := len(data) - len(bytes.TrimLeft(data, " \t\r\n"))
+	members, err := objectMembers(data[start:], start)
+	if err != nil {
+		return []configProblem{problem(start, "settings must be a JSON object")}
+	}
+	problems := checkSettingMembers(members, "", problem)
+	for _, m := range members {
+		if m.Key != "profiles" {
+			continue
+		}
+		profiles, err := objectMembers(m.Value, m.ValueOffset)
+		if err != nil {
+			// already reported as having the wrong type.
+			continue
+		}
+		for _, profile := range profiles {
+			inner, err := objectMembers(profile.Value, profile.ValueOffset)
+			if err != nil {
+				problems = append(problems, problem(profile.ValueOffset, "profile %q must be an object", profile.Key))
+				continue
+			}
+			problems = append(problems, checkSettingMembers(inner, profile.Key, problem)...)
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Line != problems[j].Line {
+			return problems[i].Line < problems[j].Line
+		}
+		return problems[i].Column < problems[j].Column
+	})
+	return problems
+}
+
+// Checks the members of a settings object, either the top-level one or a profile.
+func checkSettingMembers(members []jsonMember, profile string, problem func(offset int, format string, args ...any) configProblem) []configProblem {
+	var problems []configProblem
+	keys := settingKeys()
+	for _, m := range members {
+		var setting Setting
+		field, err := settingField(&setting, m.Key)
+		if err != nil {
+			message := fmt.Sprintf("unknown setting %q", m.Key)
+			if suggestion := closestSettingKey(m.Key, keys); suggestion != "" {
+				message += fmt.Sprintf("; did you mean %q?", suggestion)
+			}
+			problems = append(problems, problem(m.KeyOffset, "%s", message))
+			continue
+		}
+		if profile != "" && (m.Key == "profile" || m.Key == "profiles") {
+			problems = append(problems, problem(m.KeyOffset, "profile %q cannot set %q", profile, m.Key))
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(m.Value))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(field.Addr().Interface()); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				name, offset := m.Key, m.ValueOffset
+				if typeErr.Field != "" {
+					name += "." + typeErr.Field
+					offset = innerOffset(m, strings.Split(typeErr.Field, ".")[0], false)
+				}
+				problems = append(problems, problem(offset, "%s must be %s, not %s", name, jsonTypeName(typeErr.Type.Kind()), typeErr.Value))
+			} else if unknown, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+				unknown = strings.Trim(unknown, `"`)
+				problems = append(problems, problem(innerOffset(m, unknown, true), "unknown setting \"%s.%s\"", m.Key, unknown))
+			} else {
+				problems = append(problems, problem(m.ValueOffset, "%s: %s", m.Key, strings.TrimPrefix(err.Error(), "json: ")))
+			}
+			continue
+		}
+		if field.Kind() == reflect.String {
+			if field.String() == "FILLIN" {
+				problems = append(problems, problem(m.ValueOffset, "%s is still a placeholder; run `deepl-translate-cli config init`, or set it with `config set`", m.Key))
+			} else if err := validateSetting(m.Key, field.String()); err != nil {
+				problems = append(problems, problem(m.ValueOffset, "%s", err))
+			}
+		} else if _, ok := settingValidators[m.Key]; ok {
+			if err := validateSetting(m.Key, fmt.Sprint(field.Interface())); err != nil {
+				problems = append(problems, problem(m.ValueOffset, "%s", err))
+			}
+		}
+	}
+	return problems
+}
+
+// Returns the offset of the key (or value) of a member inside the object m,
+// or that of m's value if there is no such member.
+func innerOffset(m jsonMember, key string, ofKey bool) int {
+	inner, err := objectMembers(m.Value, m.ValueOffset)
+	if err != nil {
+		return m.ValueOffset
+	}
+	for _, i := range inner {
+		if i.Key == key {
+			if ofKey {
+				return i.KeyOffset
+			}
+			return i.ValueOffset
+		}
+	}
+	return m.ValueOffset
+}
+
+// Describes a Go kind the way the settings file would have it.
+func jsonTypeName(kind reflect.Kind) string {
+	switch kind {
+		case reflect.String:
+			return "a string"
+		case reflect.Bool:
+			return "true or false"
+		case reflect.Int, reflect.Float64:
+			return "a number"
+		default:
+			return "an object"
+	}
+}
+
+// Returns the known setting most similar to key, if any is similar enough to be a likely typo.
+func closestSettingKey(key string, keys []string) string {
+	best, bestScore := "", 0.6
+	for _, k := range keys {
+		if score := similarity(key, k, bestScore); score > bestScore {
+			best, bestScore = k, score
+		}
+	}
+	return best
+}
+
+// Checks the settings files, writing every problem as "path:line:column: message";
+// returns the number of problems found.
+func validateSettingsFiles(w io.Writer, paths []string) (int, error) {
+	count := 0
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return count, err
+		}
+		problems := checkSettingsData(data)
+		for _, p := range problems {
+			fmt.Fprintf(w, "%s:%s\n", path, p)
+		}
+		if len(problems) == 0 {
+			fmt.Fprintf(w, "%s: OK\n", path)
+		}
+		count += len(problems)
+	}
+	return count, nil
+}
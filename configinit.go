@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+	"github.com/lmorg/readline"
+	"github.com/mattn/go-isatty"
+)
+
+// Asks one question, returning the (trimmed) answer; choices, by value, are offered for completion.
+// An empty answer means the default; so does the end of the input.
+type askFunc func(prompt string, choices map[string]string) (string, error)
+
+// Asks on the terminal, with tab completion of the choices.
+func askTerminal(prompt string, choices map[string]string) (string, error) {
+	rl := readline.NewInstance()
+	rl.SetPrompt(prompt)
+	rl.History = new(readline.NullHistory)
+	rl.TabCompleter = func(line []rune, pos int, _ readline.DelayedTabContext) (string, []string, map[string]string, readline.TabDisplayType) {
+		typed := string(line[:pos])
+		var suggestions []string
+		descriptions := make(map[string]string)
+		for _, value := range sortedChoices(choices) {
+			if len(value) >= len(typed) && strings.EqualFold(value[:len(typed)], typed) {
+				suggestion := value[len(typed):]
+				suggestions = append(suggestions, suggestion)
+				descriptions[suggestion] = choices[value]
+			}
+		}
+		return typed, suggestions, descriptions, readline.TabDisplayList
+	}
+	answer, err := rl.Readline()
+	if errors.Is(err, readline.CtrlC) {
+		return "", fmt.Errorf("interrupted")
+	}
+	if errors.Is(err, readline.EOF) {
+		return "", nil
+	}
+	return strings.TrimSpace(answer), err
+}
+
+// Asks by reading one line at a time, e.g. from a script piping in the answers.
+func askLines(in io.Reader, out io.Writer) askFunc {
+	reader := bufio.NewReader(in)
+	return func(prompt string, choices map[string]string) (string, error) {
+		fmt.Fprint(out, prompt)
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		fmt.Fprintln(out)
+		return strings.TrimSpace(line), nil
+	}
+}
+
+// Returns the way to ask questions: on the terminal if there is one, otherwise line by line.
+func askFor(in *os.File, out io.Writer) askFunc {
+	if isatty.IsTerminal(in.Fd()) || isatty.IsCygwinTerminal(in.Fd()) {
+		return askTerminal
+	}
+	return askLines(in, out)
+}
+
+func sortedChoices(choices map[string]string) []string {
+	values := make([]string, 0, len(choices))
+	for value := range choices {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// Maps language codes to their names, as choices for askFunc.
+func languageChoices(langs []deepl.DeepLLanguagesResponse) map[string]string {
+	choices := make(map[string]string, len(langs))
+	for _, lang := range langs {
+		choices[lang.Language] = lang.Name
+	}
+	return choices
+}
+
+// Asks until the answer is one of the choices (ignoring case), or empty for the default.
+// Without choices, any answer goes.
+func askChoice(ask askFunc, out io.Writer, question string, def string, choices map[string]string) (string, error) {
+	for {
+		answer, err := ask(fmt.Sprintf("%s [%s]: ", question, def), choices)
+		if err != nil {
+			return "", err
+		}
+		if answer == "" {
+			return def, nil
+		}
+		if len(choices) == 0 {
+			return answer, nil
+		}
+		for value := range choices {
+			if strings.EqualFold(value, answer) {
+				return value, nil
+			}
+		}
+		fmt.Fprintf(out, "%q is not one of: %s (press TAB to see them)\n", answer, strings.Join(sortedChoices(choices), ", "))
+	}
+}
+
+// Asks for the basic settings: the language pair and the formality (if the target language
+// supports it). The languages, if known, are offered as choices.
+func askSettings(ask askFunc, out io.Writer, sources, targets []deepl.DeepLLanguagesResponse) (map[string]json.RawMessage, error) {
+	defaults := defaultSettings()
+	answers := make(map[string]any)
+
+	sourceLang, err := askChoice(ask, out, "Source language", defaults.SourceLang, languageChoices(sources))
+	if err != nil {
+		return nil, err
+	}
+	answers["source_lang"] = sourceLang
+	targetLang, err := askChoice(ask, out, "Target language", defaults.TargetLang, languageChoices(targets))
+	if err != nil {
+		return nil, err
+	}
+	answers["target_lang"] = targetLang
+
+	formality := len(targets) == 0
+	for _, lang := range targets {
+		if lang.Language == targetLang {
+			formality = lang.SupportsFormality
+		}
+	}
+	if formality {
+		answer, err := askChoice(ask, out, "Formality", "default", settingChoices["formality"])
+		if err != nil {
+			return nil, err
+		}
+		if answer != "default" {
+			answers["formality"] = answer
+		}
+	}
+
+	values := make(map[string]json.RawMessage, len(answers))
+	for key, answer := range answers {
+		raw, err := json.Marshal(answer)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = raw
+	}
+	return values, nil
+}
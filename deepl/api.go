@@ -13,40 +13,91 @@ import (
 // Generic API call, takes method, URL parameters and a JSON object to fill,
 // validates & parses the response and unmarshals it into the JSON object,
 // or throws an error.
-// NOTE: Closes the HTTP response that was opened.
+// With a key pool, a request refused for lack of quota is repeated with the next key.
 func (c *DeepLClient) apiCall(method string, params url.Values, jsonObject any) error {
+	if c.Pool == nil {
+		_, err := c.call(method, c.Endpoint, c.AuthKey, params, jsonObject)
+		return err
+	}
+	for {
+		key, i := c.Pool.Current()
+		params.Set("auth_key", key.AuthKey)
+		status, err := c.call(method, c.poolEndpoint(key), key.AuthKey, params, jsonObject)
+		if status != StatusQuotaExceeded {
+			return err
+		}
+		if !c.Pool.failover(i, c.hasAllowance) {
+			return fmt.Errorf("%s; all %d keys in the pool have exhausted their quota", err, len(c.Pool.Keys))
+		}
+		if c.Debug > 0 {
+			next, _ := c.Pool.Current()
+			c.logf("quota of key %q exhausted, going on with key %q\n", key.Name, next.Name)
+		}
+	}
+}
+
+// Writes a debugging or key pool message.
+func (c *DeepLClient) logf(format string, args ...any) {
+	w := c.Log
+	if w == nil {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, format, args...)
+}
+
+// Makes one single call to endpoint, with the given key; returns the HTTP status code (0 if there was no response).
+// NOTE: Closes the HTTP response that was opened.
+func (c *DeepLClient) call(method string, endpoint string, authKey string, params url.Values, jsonObject any) (int, error) {
 	// If we're debugging, show what was printed out:
 	if c.Debug > 1 {
-		fmt.Fprintf(os.Stderr, "Values being called using %q to API endpoint (%s): %q\n",
+		c.logf("Values being called using %q to API endpoint (%s): %q\n",
 			method,
-			c.Endpoint,
+			endpoint,
 			params.Encode(),
 		)
 	}
 
 	// http.PostForm() unfortunately doesn't allow us to set headers, and we need to send the authorization
 	// in the headers, not in the body... (gwyneth 20231104)
-	client := &http.Client{}
-	req, err := http.NewRequest(method, c.Endpoint, strings.NewReader(params.Encode()))
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest(method, endpoint, strings.NewReader(params.Encode()))
 	if err != nil {
-		return err
+		return 0, err
 	}
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Add("Authorization", "DeepL-Auth-Key " + c.AuthKey)
+	req.Header.Add("Authorization", "DeepL-Auth-Key " + authKey)
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer resp.Body.Close()
 
 	if err := validateResponse(resp); err != nil {
-		return err
+		if resp.StatusCode == http.StatusForbidden {
+			if hint := endpointHint(endpoint, authKey); hint != "" {
+				return resp.StatusCode, fmt.Errorf("%s; %s", err, hint)
+			}
+		}
+		return resp.StatusCode, err
 	}
-	err = parseResponse(resp.Body, jsonObject)
-	if err != nil {
-		return err
+	return resp.StatusCode, parseResponse(resp.Body, jsonObject)
+}
+
+// A 403 is what DeepL answers when a Free key is used with the Pro endpoint, or vice versa;
+// returns a hint if that looks like the cause.
+func endpointHint(endpoint string, authKey string) string {
+	onFree := strings.HasPrefix(endpoint, GetEndpoint(false))
+	onPro := strings.HasPrefix(endpoint, GetEndpoint(true))
+	switch {
+		case IsFreeKey(authKey) && onPro:
+			return "the API key looks like a Free one (it ends in \":fx\"), but was sent to the Pro endpoint; use the Free endpoint instead"
+		case !IsFreeKey(authKey) && onFree:
+			return "the API key looks like a Pro one (it does not end in \":fx\"), but was sent to the Free endpoint; use the Pro endpoint instead"
 	}
-	return nil
+	return ""
 }
 
 // Validates the response based on its status code, decoding the returned JSON.
@@ -2,9 +2,11 @@ package deepl
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 )
 
 type DeepL interface {
@@ -25,9 +27,23 @@ type DeepLClient struct {
 	NonSplittingTags	string	`json:"non_splitting_tags"`		// List of comma-separated XML tags.
 	SplittingTags		string	`json:"splitting_tags"`			// List of comma-separated XML tags.
 	IgnoreTags			string	`json:"ignore_tags"`			// List of comma-separated XML tags.
+	Formality			string	`json:"formality"`				// "default", "more", "less", "prefer_more", "prefer_less".
+	GlossaryID			string	`json:"glossary_id"`			// Requires SourceLang to be set.
+	Context				string	`json:"context"`				// Text that helps with the translation, without being translated (nor billed) itself.
+	Pool				*KeyPool	`json:"-"`					// If set, its keys are used instead of AuthKey.
+	HTTPClient			*http.Client	`json:"-"`			// Reused for every call, keeping connections open; nil means http.DefaultClient.
+	Log					io.Writer	`json:"-"`					// Where debugging and key pool messages go; nil means os.Stderr.
 	Debug				int		`json:"debug"`					// Debug/verbosity level, 0 is no debugging.
 }
 
+// Limits for a single translation request.
+// SEE: https://developers.deepl.com/docs/resources/usage-limits
+const (
+	MaxTextsPerRequest	= 50			// Maximum number of `text` parameters.
+	MaxRequestSize		= 128 * 1024	// Maximum size of the request body, in bytes.
+	MaxTextsSize		= 120 * 1024	// What we allow for the (URL-encoded) texts, leaving some room for the other parameters.
+)
+
 type DeepLResponse struct {
 	Translations []Translated
 }
@@ -45,6 +61,28 @@ func (c *DeepLClient) Translate(text string) ([]string, error) {
 		return nil, fmt.Errorf("received empty string for translation")
 	}
 
+	translations, err := c.TranslateTexts([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	r := []string{}
+	for _, translated := range translations {
+		r = append(r, translated.Text)
+	}
+	return r, nil
+}
+
+// TranslateTexts sends several texts in one single request. DeepL treats each one
+// separately, and returns exactly one translation per text, in the same order.
+// Note that the API accepts at most MaxTextsPerRequest texts per call.
+func (c *DeepLClient) TranslateTexts(texts []string) ([]Translated, error) {
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("received no texts for translation")
+	}
+	if len(texts) > MaxTextsPerRequest {
+		return nil, fmt.Errorf("too many texts in one request (%d, maximum is %d)", len(texts), MaxTextsPerRequest)
+	}
+
 	// TODO(gwyneth): Make the call with JSON, it probably makes much more sense that way.
 	params := url.Values{}
 	params.Add("auth_key",				c.AuthKey)
@@ -58,7 +96,22 @@ func (c *DeepLClient) Translate(text string) ([]string, error) {
 	params.Add("non_splitting_tags",	c.NonSplittingTags)
 	params.Add("splitting_tags",		c.SplittingTags)
 	params.Add("ignore_tags",			c.IgnoreTags)
-	params.Add("text",					text)
+	// only sent if set, since DeepL rejects them for some language pairs.
+	if c.Formality != "" {
+		params.Add("formality",			c.Formality)
+	}
+	if c.GlossaryID != "" {
+		params.Add("glossary_id",		c.GlossaryID)
+	}
+	if c.Context != "" {
+		params.Add("context",			c.Context)
+	}
+	for _, text := range texts {
+		if len(text) == 0 {
+			return nil, fmt.Errorf("received empty string for translation")
+		}
+		params.Add("text", text)
+	}
 
 	var parsed DeepLResponse
 
@@ -66,12 +119,39 @@ func (c *DeepLClient) Translate(text string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	r := []string{}
-	for _, translated := range parsed.Translations {
-		r = append(r, translated.Text)
+	if len(parsed.Translations) != len(texts) {
+		return nil, fmt.Errorf("expected %d translations, got %d", len(texts), len(parsed.Translations))
 	}
-	return r, nil
+	return parsed.Translations, nil
 }
+
+// Groups texts into batches that fit into a single request each, keeping their order.
+// A text that is too large on its own still gets a batch of its own (and will be rejected by DeepL);
+// callers are expected to split such texts first.
+func BatchTexts(texts []string) [][]string {
+	var batches [][]string
+	var batch []string
+	size := 0
+	for _, text := range texts {
+		textSize := len("&text=") + len(url.QueryEscape(text))
+		if len(batch) > 0 && (len(batch) == MaxTextsPerRequest || size+textSize > MaxTextsSize) {
+			batches = append(batches, batch)
+			batch, size = nil, 0
+		}
+		batch = append(batch, text)
+		size += textSize
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// Free API keys end in ":fx"; any other key is taken to be a Pro one.
+func IsFreeKey(authKey string) bool {
+	return strings.HasSuffix(authKey, ":fx")
+}
+
 // Returns the base DeepL API endpoint for either the Free or the Pro Plan (if IsPro is true).
 func GetEndpoint(isPro bool) string {
 	if isPro {
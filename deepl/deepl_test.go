@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
@@ -209,3 +210,135 @@ func TestTranslate(t *testing.T) {
 		t.Errorf("The translation ought to have been empty, but got the following instead: %v", translateds)
 	}
 }
+
+// TranslateTexts should send one `text` parameter per text, and return the translations in order.
+func TestTranslateTexts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("Invalid request body: %s", err)
+		}
+		if _, ok := r.PostForm["formality"]; ok && r.PostForm.Get("formality") != "less" {
+			t.Errorf("Unexpected formality %q", r.PostForm.Get("formality"))
+		}
+		var response DeepLResponse
+		for _, text := range r.PostForm["text"] {
+			response.Translations = append(response.Translations, Translated{DetectedSourceLanguage: "EN", Text: strings.ToUpper(text)})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	client := DeepLClient{Endpoint: server.URL, TargetLang: "DE"}
+	translateds, err := client.TranslateTexts([]string{"one", "two", "three"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []string{"ONE", "TWO", "THREE"}
+	for i, translated := range translateds {
+		if translated.Text != expected[i] {
+			t.Errorf("Translation %d\nExpected: %s\nActual: %s", i, expected[i], translated.Text)
+		}
+	}
+
+	client.Formality = "less"
+	if _, err := client.TranslateTexts([]string{"four"}); err != nil {
+		t.Errorf("Unexpected error with formality: %s", err)
+	}
+
+	if _, err := client.TranslateTexts(make([]string, MaxTextsPerRequest+1)); err == nil {
+		t.Errorf("Expected an error when sending more than %d texts", MaxTextsPerRequest)
+	}
+}
+
+func TestEndpointHint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Wrong endpoint"})
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		endpoint	string
+		authKey		string
+		hint		bool
+	}{
+		{GetEndpoint(true), "0000-0000:fx", true},
+		{GetEndpoint(false), "0000-0000", true},
+		{GetEndpoint(false), "0000-0000:fx", false},
+		{GetEndpoint(true), "0000-0000", false},
+	}
+	for _, test := range tests {
+		if hint := endpointHint(test.endpoint+"/usage", test.authKey); (hint != "") != test.hint {
+			t.Errorf("Key %s on %s\nUnexpected hint: %q", test.authKey, test.endpoint, hint)
+		}
+	}
+	if !IsFreeKey("0000-0000:fx") || IsFreeKey("0000-0000") {
+		t.Errorf("Free keys should be those ending in :fx")
+	}
+
+	// a server that is neither endpoint never gets a hint.
+	client := DeepLClient{Endpoint: server.URL, AuthKey: "0000-0000:fx"}
+	if _, err := client.Usage(); err == nil || strings.Contains(err.Error(), "endpoint instead") {
+		t.Errorf("Expected a plain 403 error, got: %v", err)
+	}
+}
+
+// With a key pool, a request refused for lack of quota should go on with the next key that has some left.
+func TestKeyPool(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "DeepL-Auth-Key ")
+		calls = append(calls, key+" "+r.URL.Path)
+		switch {
+			case r.URL.Path == "/usage" && key == "empty":
+				json.NewEncoder(w).Encode(DeepLUsageResponse{CharacterCount: 500000, CharacterLimit: 500000})
+			case r.URL.Path == "/usage":
+				json.NewEncoder(w).Encode(DeepLUsageResponse{CharacterCount: 10, CharacterLimit: 500000})
+			case key == "first" || key == "empty":
+				w.WriteHeader(StatusQuotaExceeded)
+				json.NewEncoder(w).Encode(map[string]string{"message": "Quota Exceeded"})
+			default:
+				json.NewEncoder(w).Encode(DeepLResponse{Translations: []Translated{{Text: "translated by " + key}}})
+		}
+	}))
+	defer server.Close()
+
+	pool := NewKeyPool([]PoolKey{{Name: "1", AuthKey: "first"}, {Name: "2", AuthKey: "empty"}, {Name: "3", AuthKey: "third"}})
+	client := DeepLClient{Endpoint: server.URL + "/translate", TargetLang: "DE", Pool: pool}
+	translateds, err := client.TranslateTexts([]string{"text"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if translateds[0].Text != "translated by third" {
+		t.Errorf("Expected the third key to be used, got %q\nCalls: %q", translateds[0].Text, calls)
+	}
+	if key, _ := pool.Current(); key.Name != "3" {
+		t.Errorf("Expected the pool to stay with the third key, got %q", key.Name)
+	}
+
+	// once all keys are exhausted, the error comes through.
+	pool = NewKeyPool([]PoolKey{{Name: "1", AuthKey: "first"}, {Name: "2", AuthKey: "empty"}})
+	client.Pool = pool
+	if _, err := client.TranslateTexts([]string{"text"}); err == nil || !strings.Contains(err.Error(), "all 2 keys") {
+		t.Errorf("Expected an error about all keys being exhausted, got: %v", err)
+	}
+}
+
+func TestGlossaryLanguagePairs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("Expected a GET request, got %s", r.Method)
+		}
+		w.Write([]byte(`{"supported_languages": [{"source_lang": "de", "target_lang": "en"}, {"source_lang": "en", "target_lang": "de"}]}`))
+	}))
+	defer server.Close()
+
+	client := DeepLClient{Endpoint: server.URL}
+	pairs, err := client.GlossaryLanguagePairs()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(pairs) != 2 || pairs[1] != (GlossaryPair{SourceLang: "en", TargetLang: "de"}) {
+		t.Errorf("Unexpected pairs: %v", pairs)
+	}
+}
@@ -0,0 +1,37 @@
+// This file handles calls related to glossaries.
+package deepl
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// One glossary, as returned by the /glossaries API call (without its entries).
+type Glossary struct {
+	GlossaryID		string	`json:"glossary_id"`
+	Name			string	`json:"name"`
+	Ready			bool	`json:"ready"`
+	SourceLang		string	`json:"source_lang"`
+	TargetLang		string	`json:"target_lang"`
+	CreationTime	string	`json:"creation_time"`
+	EntryCount		int		`json:"entry_count"`
+}
+
+type DeepLGlossariesResponse struct {
+	Glossaries []Glossary	`json:"glossaries"`
+}
+
+// List all glossaries —
+// Retrieve the list of all glossaries and their meta-information, but not the glossary entries.
+func (c *DeepLClient) Glossaries() ([]Glossary, error) {
+	params := url.Values{}
+	params.Add("auth_key", c.AuthKey)
+
+	var resp DeepLGlossariesResponse
+
+	err := c.apiCall(http.MethodGet, params, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Glossaries, nil
+}
@@ -0,0 +1,104 @@
+// This file handles pools of API keys, which are used in turn as their quotas run out.
+package deepl
+
+import (
+	"strings"
+	"sync"
+)
+
+// DeepL's status code for "Quota exceeded. The character limit has been reached."
+const StatusQuotaExceeded = 456
+
+// One API key in a pool.
+type PoolKey struct {
+	Name	string	// For reporting, e.g. who owns the key; need not be unique.
+	AuthKey	string
+	BaseURL	string	// Base endpoint for this key (see GetEndpoint); empty means the one matching the key.
+}
+
+// Returns the base endpoint for the key.
+func (k PoolKey) Endpoint() string {
+	if k.BaseURL != "" {
+		return k.BaseURL
+	}
+	return GetEndpoint(!IsFreeKey(k.AuthKey))
+}
+
+// A pool of API keys. Requests use the current key until DeepL reports that its quota
+// is exhausted; then they go on with the next key that still has some allowance left.
+// A pool may be shared by several clients, even concurrently.
+type KeyPool struct {
+	Keys		[]PoolKey
+	mu			sync.Mutex
+	current		int
+	exhausted	map[int]bool
+}
+
+func NewKeyPool(keys []PoolKey) *KeyPool {
+	return &KeyPool{Keys: keys, exhausted: make(map[int]bool)}
+}
+
+// Returns the key currently in use, and its position in the pool.
+func (p *KeyPool) Current() (PoolKey, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Keys[p.current], p.current
+}
+
+// Marks the key at position i as exhausted, and moves on to the next key for which hasAllowance
+// returns true. Returns false if there is none left. If another request already moved on
+// from i, nothing else happens.
+func (p *KeyPool) failover(i int, hasAllowance func(key PoolKey) bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.exhausted[i] = true
+	if p.current != i && !p.exhausted[p.current] {
+		return true
+	}
+	for next := 1; next < len(p.Keys); next++ {
+		candidate := (i + next) % len(p.Keys)
+		if p.exhausted[candidate] {
+			continue
+		}
+		if hasAllowance(p.Keys[candidate]) {
+			p.current = candidate
+			return true
+		}
+		p.exhausted[candidate] = true
+	}
+	return false
+}
+
+// Rewrites the client's endpoint (which is for whatever key it was set up with) to the one for key,
+// keeping the path of the call.
+func (c *DeepLClient) poolEndpoint(key PoolKey) string {
+	for _, base := range []string{GetEndpoint(true), GetEndpoint(false)} {
+		if path, ok := strings.CutPrefix(c.Endpoint, base); ok {
+			return key.Endpoint() + path
+		}
+	}
+	// not a DeepL endpoint (e.g. a test server); leave it alone.
+	return c.Endpoint
+}
+
+// Checks whether the key has any characters left in the current billing period.
+func (c *DeepLClient) hasAllowance(key PoolKey) bool {
+	usageClient := DeepLClient{
+		Endpoint:	c.poolEndpoint(key),
+		AuthKey:	key.AuthKey,
+		Debug:		c.Debug,
+		Log:		c.Log,
+	}
+	// the path of the call is replaced by the one for usage.
+	if i := strings.LastIndex(usageClient.Endpoint, "/"); i >= 0 {
+		usageClient.Endpoint = usageClient.Endpoint[:i] + "/usage"
+	}
+	usage, err := usageClient.Usage()
+	if err != nil {
+		if c.Debug > 0 {
+			c.logf("skipping key %q: %s\n", key.Name, err)
+		}
+		return false
+	}
+	return usage.CharacterLimit == 0 || usage.CharacterCount < usage.CharacterLimit
+}
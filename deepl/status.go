@@ -3,42 +3,30 @@ package deepl
 
 import (
 	//	"encoding/json"
-	"fmt"
 	"net/http"
 	"net/url"
 )
 
 type DeepLUsageResponse struct {
-	CharacterCount		int	`json:"character_count,omitempty"`// Characters translated so far in the current billing period.
-	CharacterLimit		int	`json:"character_limit,omitempty"`// Current maximum number of characters that can be translated per billing period.
-	DocumentLimit		int	`json:"document_limit,omitempty"` // Documents translated so far in the current billing period.
-	DocumentCount		int	`json:"document_count,omitempty"` // Current maximum number of documents that can be translated per billing period.
-	TeamDocumentLimit	int	`json:"team_document_limit,omitempty"` // Documents translated by all users in the team so far in the current billing period.
-	TeamDocumentCount	int	`json:"team_document_count,omitempty"` // Current maximum number of documents that can be translated by the team per billing period.
+	CharacterCount		int	`json:"character_count"`				// Characters translated so far in the current billing period.
+	CharacterLimit		int	`json:"character_limit"`				// Current maximum number of characters that can be translated per billing period.
+	DocumentCount		int	`json:"document_count,omitempty"`		// Documents translated so far in the current billing period.
+	DocumentLimit		int	`json:"document_limit,omitempty"`		// Current maximum number of documents that can be translated per billing period.
+	TeamDocumentCount	int	`json:"team_document_count,omitempty"`	// Documents translated by all users in the team so far in the current billing period.
+	TeamDocumentLimit	int	`json:"team_document_limit,omitempty"`	// Current maximum number of documents that can be translated by the team per billing period.
 }
 
 // Check Usage and Limits —
 // Retrieve usage information within the current billing period together with the corresponding account limits.
-func (c *DeepLClient) Usage() (string, error) {
+// Fields that do not apply to the account (e.g. documents, on most plans) are left at zero.
+func (c *DeepLClient) Usage() (DeepLUsageResponse, error) {
 	params := url.Values{}
 	params.Add("auth_key", c.AuthKey)
 
 	var resp DeepLUsageResponse
 
 	err := c.apiCall(http.MethodPost, params, &resp)
-	if err != nil {
-		return "", err
-	}
-
- 	return fmt.Sprintf(
-		"Character Count: %d; Character Limit: %d; Document Limit: %d; Document Count: %d; Team Document Limit: %d; Team Document Count: %d.",
-		resp.CharacterCount,
-		resp.CharacterLimit,
-		resp.DocumentLimit,
-		resp.DocumentCount,
-		resp.TeamDocumentLimit,
-		resp.TeamDocumentCount),
-	nil
+	return resp, err
 }
 
 // The /languages API call returns an array of language/name pairs and a flag
@@ -51,7 +39,7 @@ type DeepLLanguagesResponse struct {
 
 // Retrieve Supported Languages —
 // Retrieve the list of languages that are currently supported for translation, either as source or target language, respectively.
-func (c *DeepLClient) Languages() (string, error) {
+func (c *DeepLClient) Languages() ([]DeepLLanguagesResponse, error) {
 	params := url.Values{}
 	params.Add("auth_key", c.AuthKey)
 	// note: translationType was already parsed
@@ -63,25 +51,9 @@ func (c *DeepLClient) Languages() (string, error) {
 
 	err := c.apiCall(http.MethodPost, params, &langs)
 	if err != nil {
-		return "", err
-	}
-
-	// TODO(gwyneth): @coderabbitai suggests that this should be returned in different formats,
-	// namely, structured ones. One possibility would be to have different methods, _or_ pass
-	// a parameter (e.g., formatJSON, formatXML, formatYAML, formatUnstructured) and spew out
-	// whatever format is appropriate.
-	// Currently, the only use case for this function is inside a CLI, where the human reader
-	// will very likely prefer to read unstructured (but pretty-printed) language pairs...
-	// (gwyneth 20230413)
-	var r string
-	for _, lang := range langs {
-		r += lang.Language + ": " + lang.Name
-		if lang.SupportsFormality {
-			r += " (+ formality)"
-		}
-		r += "\n"
+		return nil, err
 	}
-	return r, nil
+	return langs, nil
 }
 
 
@@ -98,7 +70,7 @@ type GlossaryPair struct {
 
 // List language pairs supported by glossaries —
 // Retrieve the list of language pairs supported by the glossary feature.
-func (c *DeepLClient) GlossaryLanguagePairs() (string, error) {
+func (c *DeepLClient) GlossaryLanguagePairs() ([]GlossaryPair, error) {
 	params := url.Values{}
 	params.Add("auth_key", c.AuthKey)
 
@@ -106,12 +78,7 @@ func (c *DeepLClient) GlossaryLanguagePairs() (string, error) {
 
 	err := c.apiCall(http.MethodGet, params, &langPairs)
 	if err != nil {
-		return "", err
-	}
-
-	var r string
-	for _, langPair := range langPairs.SupportedLanguages {
-		r += langPair.SourceLang + " ⇒ " + langPair.TargetLang + "\n"
+		return nil, err
 	}
-	return r, nil
-}
\ No newline at end of file
+	return langPairs.SupportedLanguages, nil
+}
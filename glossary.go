@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+)
+
+// Name of the file, inside configDir(), where the glossaries of the account are cached.
+const glossariesCacheName = "glossaries.json"
+
+// The glossaries of the account, as cached on disk whenever DeepL lists them (i.e. whenever
+// a glossary is looked up), so that completion can offer their names without asking DeepL.
+type glossaryCache struct {
+	Fetched		time.Time			`json:"fetched"`
+	Glossaries	[]deepl.Glossary	`json:"glossaries"`
+}
+
+func glossariesCachePath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, glossariesCacheName), nil
+}
+
+// Reads the cached glossaries; a missing file means nothing is cached (nil).
+func readGlossariesCache(path string) (*glossaryCache, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cache glossaryCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("%s (occurred while reading the cached glossaries from %s)", err, path)
+	}
+	return &cache, nil
+}
+
+// Returns the ID of the glossary given by ID or name, checking that it can be used
+// for the language pair of the client, whose endpoint must point to /glossaries.
+func resolveGlossary(client *deepl.DeepLClient, nameOrID string) (string, error) {
+	if client.SourceLang == "" {
+		return "", fmt.Errorf("a glossary can only be used if the source language is set")
+	}
+	glossaries, err := client.Glossaries()
+	if err != nil {
+		return "", fmt.Errorf("%s (occurred while looking up glossary %q)", err, nameOrID)
+	}
+	if path, err := glossariesCachePath(); err == nil {
+		// the cache is only there for completion; not being able to write it does not matter.
+		writeCacheFile(path, glossaryCache{Fetched: time.Now(), Glossaries: glossaries})
+	}
+	var found []deepl.Glossary
+	for _, g := range glossaries {
+		if g.GlossaryID == nameOrID {
+			found = []deepl.Glossary{g}
+			break
+		}
+		if g.Name == nameOrID {
+			found = append(found, g)
+		}
+	}
+	// several glossaries may share a name; pick the one for this language pair.
+	var matching []deepl.Glossary
+	for _, g := range found {
+		if glossaryMatches(g, client.SourceLang, client.TargetLang) {
+			matching = append(matching, g)
+		}
+	}
+	switch {
+		case len(found) == 0:
+			return "", fmt.Errorf("no glossary with ID or name %q", nameOrID)
+		case len(matching) == 0:
+			return "", fmt.Errorf("glossary %q is for %s ⇒ %s, not %s ⇒ %s", nameOrID,
+				strings.ToUpper(found[0].SourceLang), strings.ToUpper(found[0].TargetLang), client.SourceLang, client.TargetLang)
+		case len(matching) > 1:
+			return "", fmt.Errorf("there are %d glossaries named %q for %s ⇒ %s; use the ID instead", len(matching), nameOrID, client.SourceLang, client.TargetLang)
+	}
+	if !matching[0].Ready {
+		return "", fmt.Errorf("glossary %q is not ready yet", nameOrID)
+	}
+	return matching[0].GlossaryID, nil
+}
+
+// Glossaries are bound to languages, not to regional variants (e.g. EN-GB uses an EN glossary).
+func glossaryMatches(g deepl.Glossary, sourceLang, targetLang string) bool {
+	base := func(lang string) string {
+		lang, _, _ = strings.Cut(strings.ToLower(lang), "-")
+		return lang
+	}
+	return base(g.SourceLang) == base(sourceLang) && base(g.TargetLang) == base(targetLang)
+}
+
+// Returns the pairs whose source language is from (ignoring case and regional variants, as
+// glossaries do), or all of them if from is empty.
+func glossaryPairsFrom(pairs []deepl.GlossaryPair, from string) []deepl.GlossaryPair {
+	if from == "" {
+		return pairs
+	}
+	from = baseLanguage(from)
+	var result []deepl.GlossaryPair
+	for _, pair := range pairs {
+		if strings.EqualFold(pair.SourceLang, from) {
+			result = append(result, pair)
+		}
+	}
+	return result
+}
+
+// Writes the glossary language pairs either as JSON, or as a matrix with one row per source
+// language and one column per target language.
+func printGlossaryPairs(w io.Writer, pairs []deepl.GlossaryPair, output string) error {
+	switch output {
+		case outputJSON:
+			if pairs == nil {
+				pairs = []deepl.GlossaryPair{}
+			}
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(pairs)
+		case outputTable, "":
+			if len(pairs) == 0 {
+				_, err := fmt.Fprintln(w, "No matching language pairs.")
+				return err
+			}
+			supported := make(map[[2]string]bool, len(pairs))
+			sourceSet, targetSet := make(map[string]bool), make(map[string]bool)
+			for _, pair := range pairs {
+				source, target := strings.ToUpper(pair.SourceLang), strings.ToUpper(pair.TargetLang)
+				supported[[2]string{source, target}] = true
+				sourceSet[source], targetSet[target] = true, true
+			}
+			sources, targets := sortedKeys(sourceSet), sortedKeys(targetSet)
+			tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+			// sources down, targets across.
+			fmt.Fprint(tw, "⇒")
+			for _, target := range targets {
+				fmt.Fprint(tw, "\t"+target)
+			}
+			fmt.Fprintln(tw)
+			for _, source := range sources {
+				fmt.Fprint(tw, source)
+				for _, target := range targets {
+					if supported[[2]string{source, target}] {
+						fmt.Fprint(tw, "\t✓")
+					} else {
+						fmt.Fprint(tw, "\t·")
+					}
+				}
+				fmt.Fprintln(tw)
+			}
+			return tw.Flush()
+		default:
+			return fmt.Errorf("output must be either `table` or `json` (got: %s)", output)
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
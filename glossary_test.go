@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+)
+
+func TestPrintGlossaryPairs(t *testing.T) {
+	pairs := []deepl.GlossaryPair{
+		{SourceLang: "de", TargetLang: "en"},
+		{SourceLang: "en", TargetLang: "de"},
+		{SourceLang: "en", TargetLang: "ja"},
+		{SourceLang: "ja", TargetLang: "en"},
+	}
+	var b bytes.Buffer
+	if err := printGlossaryPairs(&b, pairs, outputTable); err != nil {
+		t.Fatal(err)
+	}
+	expected := "⇒  DE EN JA\n" +
+		"DE ·  ✓  ·\n" +
+		"EN ✓  ·  ✓\n" +
+		"JA ·  ✓  ·\n"
+	if b.String() != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, b.String())
+	}
+
+	b.Reset()
+	if err := printGlossaryPairs(&b, glossaryPairsFrom(pairs, "EN-GB"), outputJSON); err != nil {
+		t.Fatal(err)
+	}
+	expected = `[
+  {
+    "source_lang": "en",
+    "target_lang": "de"
+  },
+  {
+    "source_lang": "en",
+    "target_lang": "ja"
+  }
+]
+`
+	if b.String() != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, b.String())
+	}
+}
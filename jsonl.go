@@ -0,0 +1,240 @@
+// This file implements the JSON Lines input format of the translate command, for programs.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Input formats of the translate command.
+const (
+	inputFormatText		= "text"	// The whole input is one text.
+	inputFormatJSONL	= "jsonl"	// One JSON record per line; see jsonlRecord.
+)
+
+// Options that records of the JSON Lines input may set for themselves; empty means the settings.
+type recordOptions struct {
+	TargetLang	string	`json:"target_lang,omitempty"`
+	Formality	string	`json:"formality,omitempty"`
+	Context		string	`json:"context,omitempty"`	// Helps with the translation, without being translated.
+	Glossary	string	`json:"glossary,omitempty"`	// ID or name.
+}
+
+// One record of the JSON Lines input.
+type jsonlRecord struct {
+	ID		json.RawMessage	`json:"id"`	// Whatever JSON value the caller wants back.
+	Text	*string			`json:"text"`
+	recordOptions
+}
+
+// The result for one record, written as one line of JSON.
+type jsonlResult struct {
+	ID						json.RawMessage	`json:"id"`
+	Translation				*string			`json:"translation,omitempty"`
+	TargetLang				string			`json:"target_lang,omitempty"`
+	DetectedSourceLanguage	string			`json:"detected_source_language,omitempty"`	// Only if DeepL was asked.
+	BilledCharacters		int				`json:"billed_characters"`
+	Error					string			`json:"error,omitempty"`
+}
+
+// Parses the records of an input; blank lines are skipped.
+func parseJSONL(input translationInput) ([]jsonlRecord, error) {
+	var records []jsonlRecord
+	scanner := bufio.NewScanner(strings.NewReader(input.Text))
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var record jsonlRecord
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&record); err != nil {
+			return nil, fmt.Errorf("%s (occurred while reading record on line %d of %s)", err, line, input.Name)
+		}
+		if record.Text == nil {
+			return nil, fmt.Errorf("record on line %d of %s has no text", line, input.Name)
+		}
+		if record.ID == nil {
+			record.ID = json.RawMessage("null")
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Returns a translator for records with their own options, on top of those of ts.t; setting holds
+// the source language and the target variants.
+func (ts *translationSetup) translatorFor(setting Setting, options recordOptions) (*translator, error) {
+	client := *ts.t.client
+	glossary := ts.glossary
+	if options.TargetLang != "" {
+		changed := setting
+		changed.TargetLang = options.TargetLang
+		if err := changed.resolveLanguages(ts.langs, os.Stderr); err != nil {
+			return nil, err
+		}
+		client.TargetLang = strings.ToUpper(changed.TargetLang)
+	}
+	if options.Formality != "" {
+		if err := validateSetting("formality", options.Formality); err != nil {
+			return nil, err
+		}
+		client.Formality = options.Formality
+	}
+	client.Context = options.Context
+	if options.Glossary != "" {
+		glossary = options.Glossary
+	}
+	if options.TargetLang != "" || options.Glossary != "" {
+		// the glossary must match the language pair.
+		client.GlossaryID = ""
+		if glossary != "" {
+			if ts.glossaries == nil {
+				return nil, fmt.Errorf("glossaries cannot be used without a DeepL token")
+			}
+			glossaries := *ts.glossaries
+			glossaries.SourceLang, glossaries.TargetLang = client.SourceLang, client.TargetLang
+			id, err := resolveGlossary(&glossaries, glossary)
+			if err != nil {
+				return nil, err
+			}
+			client.GlossaryID = id
+		}
+	}
+	t := *ts.t
+	t.client = &client
+	return &t, nil
+}
+
+// Records with the same options, which are translated together.
+type recordGroup struct {
+	options	recordOptions
+	records	[]int	// Positions of the records.
+	t		*translator
+	err		error	// Why the records cannot be translated, if they cannot.
+	plan	*translationPlan
+}
+
+// Groups the records by options, in order of first appearance, and plans the translation of each
+// group. Records whose options are wrong get the error in their group, instead of stopping the others.
+func (ts *translationSetup) planRecords(setting Setting, names []string, records []jsonlRecord) ([]*recordGroup, error) {
+	var groups []*recordGroup
+	byOptions := make(map[recordOptions]*recordGroup)
+	for i, record := range records {
+		g, ok := byOptions[record.recordOptions]
+		if !ok {
+			g = &recordGroup{options: record.recordOptions}
+			g.t, g.err = ts.translatorFor(setting, record.recordOptions)
+			byOptions[record.recordOptions] = g
+			groups = append(groups, g)
+		}
+		g.records = append(g.records, i)
+	}
+	for _, g := range groups {
+		if g.err != nil {
+			continue
+		}
+		inputs := make([]translationInput, len(g.records))
+		for i, r := range g.records {
+			inputs[i] = translationInput{Name: names[r], Text: *records[r].Text}
+		}
+		var err error
+		if g.plan, err = g.t.plan(inputs); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// Puts the plans of the groups together, for estimating them all at once; each group is one line.
+func combineGroups(groups []*recordGroup) *translationPlan {
+	combined := &translationPlan{}
+	for _, g := range groups {
+		if g.plan == nil {
+			continue
+		}
+		var segments []segment
+		billable, characters := 0, 0
+		for i := range g.plan.inputs {
+			segments = append(segments, g.plan.segments[i]...)
+			billable += g.plan.billable[i]
+			characters += g.plan.characters[i]
+		}
+		name := fmt.Sprintf("%d records ⇒ %s", len(g.records), g.t.client.TargetLang)
+		if g.options.Formality != "" {
+			name += ", formality " + g.options.Formality
+		}
+		if g.options.Glossary != "" {
+			name += ", glossary " + g.options.Glossary
+		}
+		if g.options.Context != "" {
+			name += ", with context"
+		}
+		combined.inputs = append(combined.inputs, translationInput{Name: name})
+		combined.segments = append(combined.segments, segments)
+		combined.billable = append(combined.billable, billable)
+		combined.characters = append(combined.characters, characters)
+	}
+	return combined
+}
+
+// Translates the groups concurrently, and writes one result per record, in the order of the records.
+// Returns the first error, if any record could not be translated.
+func translateRecords(w io.Writer, records []jsonlRecord, groups []*recordGroup) error {
+	var translators []*translator
+	var plans []*translationPlan
+	var planned []*recordGroup
+	for _, g := range groups {
+		if g.err == nil {
+			translators = append(translators, g.t)
+			plans = append(plans, g.plan)
+			planned = append(planned, g)
+		}
+	}
+	outputs, errs := executeEach(translators, plans)
+
+	results := make([]jsonlResult, len(records))
+	var firstErr error
+	for i, g := range planned {
+		g.err = errs[i]
+		if g.err != nil {
+			continue
+		}
+		for j, r := range g.records {
+			results[r] = jsonlResult{
+				Translation:			&outputs[i][j],
+				TargetLang:				g.t.client.TargetLang,
+				DetectedSourceLanguage:	g.plan.detectedLanguage(j),
+				BilledCharacters:		g.plan.billable[j],
+			}
+		}
+	}
+	for _, g := range groups {
+		if g.err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = g.err
+		}
+		for _, r := range g.records {
+			results[r] = jsonlResult{Error: g.err.Error()}
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for i := range results {
+		results[i].ID = records[i].ID
+		if err := encoder.Encode(results[i]); err != nil {
+			return err
+		}
+	}
+	return firstErr
+}
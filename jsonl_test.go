@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+)
+
+func TestParseJSONL(t *testing.T) {
+	records, err := parseJSONL(translationInput{Name: "-", Text: `{"id": 1, "text": "Hello"}` + "\n\n" + `{"text": "Bye", "target_lang": "DE"}` + "\n"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(records) != 2 || string(records[0].ID) != "1" || string(records[1].ID) != "null" || records[1].TargetLang != "DE" {
+		t.Errorf("Unexpected records: %#v", records)
+	}
+
+	for text, expected := range map[string]string{
+		`{"id": 1}`:							"line 1 of - has no text",
+		"\n" + `{"id": 1, "text": "a", "lang": "DE"}`:	`line 2 of -`,
+		`{"id": 1, "text": "a"`:				`line 1 of -`,
+	} {
+		if _, err := parseJSONL(translationInput{Name: "-", Text: text}); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error about %q for %s, got %v", expected, text, err)
+		}
+	}
+}
+
+func TestTranslateRecords(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		requests = append(requests, r.PostForm.Get("target_lang")+":"+r.PostForm.Get("formality")+":"+r.PostForm.Get("context")+":"+strings.Join(r.PostForm["text"], "|"))
+		mu.Unlock()
+		var response deepl.DeepLResponse
+		for _, text := range r.PostForm["text"] {
+			response.Translations = append(response.Translations, deepl.Translated{DetectedSourceLanguage: "EN", Text: strings.ToUpper(text)})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	setting := Setting{TargetLang: "JA"}
+	ts := &translationSetup{
+		t:			&translator{client: &deepl.DeepLClient{Endpoint: server.URL, TargetLang: "JA"}},
+		langs:		testLanguages,
+		targets:	[]string{"JA"},
+	}
+	input := strings.Join([]string{
+		`{"id": "a", "text": "Hello."}`,
+		`{"id": "b", "text": "Hello.", "target_lang": "german", "formality": "more"}`,
+		`{"id": "c", "text": "Bye."}`,
+		`{"id": "d", "text": "Hello."}`,
+		`{"id": "e", "text": "Hi.", "target_lang": "XX"}`,
+		`{"id": "f", "text": "Go.", "context": "A game of Go"}`,
+		`{"id": "g", "text": "Hello.", "target_lang": "DE", "formality": "more", "glossary": "terms"}`,
+	}, "\n")
+	records, err := parseJSONL(translationInput{Name: stdinName, Text: input})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	names := make([]string, len(records))
+	groups, err := ts.planRecords(setting, names, records)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if total := combineGroups(groups).totalBillable(); total != 6+4+6+3 {
+		t.Errorf("Expected 19 billable characters, got %d", total)
+	}
+
+	var out bytes.Buffer
+	err = translateRecords(&out, records, groups)
+	if err == nil || !strings.Contains(err.Error(), `"XX"`) {
+		t.Errorf("Expected an error about XX, got %v", err)
+	}
+	sort.Strings(requests)
+	if expected := "DE:more::Hello.,JA:::Hello.|Bye.,JA::A game of Go:Go."; strings.Join(requests, ",") != expected {
+		t.Errorf("Expected requests: %s\nActual: %s", expected, strings.Join(requests, ","))
+	}
+	expected := []string{
+		`{"id":"a","translation":"HELLO.","target_lang":"JA","detected_source_language":"EN","billed_characters":6}`,
+		`{"id":"b","translation":"HELLO.","target_lang":"DE","detected_source_language":"EN","billed_characters":6}`,
+		`{"id":"c","translation":"BYE.","target_lang":"JA","detected_source_language":"EN","billed_characters":4}`,
+		`{"id":"d","translation":"HELLO.","target_lang":"JA","detected_source_language":"EN","billed_characters":0}`,
+		`{"id":"e","billed_characters":0,"error":"unknown target language \"XX\"; see ` + "`deepl-translate-cli languages`" + `"}`,
+		`{"id":"f","translation":"GO.","target_lang":"JA","detected_source_language":"EN","billed_characters":3}`,
+		`{"id":"g","billed_characters":0,"error":"glossaries cannot be used without a DeepL token"}`,
+	}
+	if actual := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"); strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected output:\n%s\nActual:\n%s", strings.Join(expected, "\n"), out.String())
+	}
+}
+
+func TestTranslateRecordsWithMemory(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		requests = append(requests, r.PostForm.Get("formality")+":"+strings.Join(r.PostForm["text"], "|"))
+		mu.Unlock()
+		var response deepl.DeepLResponse
+		for _, text := range r.PostForm["text"] {
+			response.Translations = append(response.Translations, deepl.Translated{DetectedSourceLanguage: "EN", Text: r.PostForm.Get("formality") + ":" + text})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	memory, _ := loadMemory(t.TempDir() + "/memory.json")
+	setting := Setting{SourceLang: "EN", TargetLang: "DE"}
+	ts := &translationSetup{
+		t:			&translator{client: &deepl.DeepLClient{Endpoint: server.URL, SourceLang: "EN", TargetLang: "DE"}, memory: memory, options: memoryOptions{SegmentBy: segmentByNone}},
+		langs:		testLanguages,
+		targets:	[]string{"DE"},
+	}
+	translate := func(input string) string {
+		records, err := parseJSONL(translationInput{Name: stdinName, Text: input})
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		groups, err := ts.planRecords(setting, make([]string, len(records)), records)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		var out bytes.Buffer
+		if err := translateRecords(&out, records, groups); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		return out.String()
+	}
+
+	translate(`{"id": 1, "text": "Hello.", "formality": "more"}`)
+	// the memory knows the formal translation, which is not the informal one; and nothing
+	// was detected for a record that never went to DeepL.
+	actual := translate(`{"id": 2, "text": "Hello.", "formality": "less"}` + "\n" + `{"id": 3, "text": "Hello.", "formality": "more"}`)
+	expected := `{"id":2,"translation":"less:Hello.","target_lang":"DE","detected_source_language":"EN","billed_characters":6}` + "\n" +
+		`{"id":3,"translation":"more:Hello.","target_lang":"DE","billed_characters":0}` + "\n"
+	if actual != expected {
+		t.Errorf("Expected output:\n%s\nActual:\n%s", expected, actual)
+	}
+	if strings.Join(requests, ",") != "more:Hello.,less:Hello." {
+		t.Errorf("Expected one request per formality, got %s", strings.Join(requests, ","))
+	}
+}
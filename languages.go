@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+)
+
+// Name of the file, inside configDir(), where the lists of languages are cached.
+const languagesCacheName = "languages.json"
+
+// How long the cached lists of languages are used before asking DeepL again, unless set otherwise.
+const defaultLanguagesTTL = 24 * time.Hour
+
+// The languages supported by DeepL, as cached on disk.
+type languageLists struct {
+	Fetched	time.Time						`json:"fetched"`	// When DeepL was last asked.
+	Source	[]deepl.DeepLLanguagesResponse	`json:"source"`
+	Target	[]deepl.DeepLLanguagesResponse	`json:"target"`
+}
+
+// One language in the `languages` listing, which merges the source and target lists.
+type languageRow struct {
+	Language			string	`json:"language"`
+	Name				string	`json:"name"`
+	Source				bool	`json:"source"`				// Valid as source_lang?
+	Target				bool	`json:"target"`				// Valid as target_lang?
+	SupportsFormality	bool	`json:"supports_formality"`	// As a target language.
+}
+
+// Where a language can be used, for humans.
+func (r languageRow) use() string {
+	switch {
+		case r.Source && r.Target:
+			return "source, target"
+		case r.Source:
+			return "source"
+		default:
+			return "target"
+	}
+}
+
+// Merges the source and target lists into one row per language code, sorted by code.
+func languageRows(lists *languageLists) []languageRow {
+	byCode := make(map[string]*languageRow)
+	var rows []*languageRow
+	row := func(lang deepl.DeepLLanguagesResponse) *languageRow {
+		r, ok := byCode[lang.Language]
+		if !ok {
+			r = &languageRow{Language: lang.Language, Name: lang.Name}
+			byCode[lang.Language] = r
+			rows = append(rows, r)
+		}
+		return r
+	}
+	for _, lang := range lists.Source {
+		row(lang).Source = true
+	}
+	for _, lang := range lists.Target {
+		r := row(lang)
+		r.Target = true
+		r.SupportsFormality = lang.SupportsFormality
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Language < rows[j].Language })
+	result := make([]languageRow, len(rows))
+	for i, r := range rows {
+		result[i] = *r
+	}
+	return result
+}
+
+// Filters for the `languages` command.
+type languageFilter struct {
+	Type		string	// "source", "target", or "all".
+	Search		string	// Substring of the code or the name, in any case.
+	Formality	bool	// Only languages supporting formality?
+}
+
+func (f languageFilter) apply(rows []languageRow) []languageRow {
+	search := strings.ToLower(f.Search)
+	var result []languageRow
+	for _, r := range rows {
+		switch {
+			case f.Type == "source" && !r.Source, f.Type == "target" && !r.Target:
+				continue
+			case f.Formality && !r.SupportsFormality:
+				continue
+			case search != "" && !strings.Contains(strings.ToLower(r.Language), search) && !strings.Contains(strings.ToLower(r.Name), search):
+				continue
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+// Writes the languages either as JSON, or as a table with one language per line; the combined
+// view (type "all") also says where each language can be used.
+func printLanguages(w io.Writer, rows []languageRow, langType string, output string) error {
+	switch output {
+		case outputJSON:
+			if rows == nil {
+				rows = []languageRow{}
+			}
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(rows)
+		case outputTable, "":
+			if len(rows) == 0 {
+				_, err := fmt.Fprintln(w, "No matching languages.")
+				return err
+			}
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			for _, r := range rows {
+				fmt.Fprintf(tw, "%s\t%s", r.Language, r.Name)
+				if langType == "all" {
+					fmt.Fprintf(tw, "\t%s", r.use())
+				}
+				if r.SupportsFormality {
+					fmt.Fprint(tw, "\t+ formality")
+				}
+				fmt.Fprintln(tw)
+			}
+			return tw.Flush()
+		default:
+			return fmt.Errorf("output must be either `table` or `json` (got: %s)", output)
+	}
+}
+
+func languagesCachePath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, languagesCacheName), nil
+}
+
+// Reads the cached lists of languages; a missing file means nothing is cached (nil).
+func readLanguagesCache(path string) (*languageLists, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lists languageLists
+	if err := json.Unmarshal(data, &lists); err != nil {
+		return nil, fmt.Errorf("%s (occurred while reading the cached languages from %s)", err, path)
+	}
+	return &lists, nil
+}
+
+// Writes the lists of languages to the cache.
+func writeLanguagesCache(path string, lists *languageLists) error {
+	return writeCacheFile(path, lists)
+}
+
+// Writes v as JSON into a cache file, replacing it at once, so that readers never see half of it.
+func writeCacheFile(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Asks DeepL for both the source and the target languages.
+func fetchLanguages(setting Setting) (*languageLists, error) {
+	client := deepl.DeepLClient{
+		Endpoint:		setting.endpoint() + "/languages",
+		AuthKey:		setting.AuthKey,
+		Pool:			setting.keyPool,
+		LanguagesType:	"source",
+		Debug:			debugLevel,
+	}
+	lists := languageLists{Fetched: time.Now()}
+	var err error
+	if lists.Source, err = client.Languages(); err != nil {
+		return nil, err
+	}
+	client.LanguagesType = "target"
+	if lists.Target, err = client.Languages(); err != nil {
+		return nil, err
+	}
+	return &lists, nil
+}
+
+// Returns the languages supported by DeepL, from the cache if it is recent enough (see
+// languages_ttl), or else from DeepL, if the API token is available. If DeepL cannot be asked,
+// an outdated cache is better than nothing; returns nil if there is nothing at all.
+func supportedLanguages(setting Setting) (*languageLists, error) {
+	path, err := languagesCachePath()
+	if err != nil {
+		return nil, err
+	}
+	cached, err := readLanguagesCache(path)
+	if err != nil {
+		// just fetch them again.
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if cached.fresh(setting) {
+		return cached, nil
+	}
+	if setting.AuthKey == "" {
+		return cached, nil
+	}
+	lists, err := fetchLanguages(setting)
+	if err != nil {
+		if cached != nil {
+			fmt.Fprintf(os.Stderr, "cannot retrieve the supported languages (%s); using the ones retrieved on %s\n", err, cached.Fetched.Format(time.DateOnly))
+			return cached, nil
+		}
+		return nil, err
+	}
+	if ttl, _ := time.ParseDuration(setting.LanguagesTTL); ttl > 0 {
+		if err := writeLanguagesCache(path, lists); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot cache the supported languages: %s\n", err)
+		}
+	}
+	return lists, nil
+}
+
+// Are the cached lists recent enough to be used without asking DeepL (see languages_ttl)?
+func (lists *languageLists) fresh(setting Setting) bool {
+	ttl, _ := time.ParseDuration(setting.LanguagesTTL)	// already validated.
+	return lists != nil && time.Since(lists.Fetched) < ttl
+}
+
+// Returns the languages for the languages command: from DeepL if refresh is set (caching them),
+// or else as supportedLanguages does. The token is only loaded if DeepL has to be asked, so that
+// a recent cache needs neither the token nor its token_command.
+func listLanguages(setting *Setting, refresh bool) (*languageLists, error) {
+	if !refresh {
+		if path, err := languagesCachePath(); err == nil {
+			if cached, _ := readLanguagesCache(path); cached.fresh(*setting) {
+				return cached, nil
+			}
+		}
+	}
+	if err := setting.loadAuthKey(); err != nil {
+		return nil, err
+	}
+	if !refresh {
+		return supportedLanguages(*setting)
+	}
+	langs, err := fetchLanguages(*setting)
+	if err != nil {
+		return nil, err
+	}
+	path, err := languagesCachePath()
+	if err != nil {
+		return nil, err
+	}
+	return langs, writeLanguagesCache(path, langs)
+}
+
+// Finds the language meant by code, which may be either a language code or a language name,
+// in any case, e.g. "pt-br", "japanese" or "English (British)".
+func findLanguage(code string, langs []deepl.DeepLLanguagesResponse) (deepl.DeepLLanguagesResponse, bool) {
+	for _, lang := range langs {
+		if strings.EqualFold(lang.Language, code) || strings.EqualFold(lang.Name, code) {
+			return lang, true
+		}
+	}
+	return deepl.DeepLLanguagesResponse{}, false
+}
+
+// Returns the language code without its regional variant, e.g. "EN" for "EN-GB".
+func baseLanguage(code string) string {
+	base, _, _ := strings.Cut(code, "-")
+	return base
+}
+
+// Returns an error for an unknown language, suggesting the most similar code or name, if any.
+func unknownLanguage(kind string, code string, langs []deepl.DeepLLanguagesResponse) error {
+	// codes are so short that a single wrong letter is already half of them, e.g. "JP" for "JA".
+	const threshold = 0.5
+	best, bestScore := deepl.DeepLLanguagesResponse{}, 0.0
+	for _, lang := range langs {
+		for _, candidate := range []string{lang.Language, lang.Name} {
+			if score := similarity(strings.ToLower(code), strings.ToLower(candidate), threshold); score >= threshold && score > bestScore {
+				best, bestScore = lang, score
+			}
+		}
+	}
+	if best.Language != "" {
+		return fmt.Errorf("unknown %s language %q; did you mean %s (%s)? See `deepl-translate-cli languages`", kind, code, best.Language, best.Name)
+	}
+	return fmt.Errorf("unknown %s language %q; see `deepl-translate-cli languages`", kind, code)
+}
+
+// Returns the source language code meant by code. A target language is taken to mean the language
+// without its regional variant, which DeepL does not accept as source, e.g. "en-gb" is "EN".
+func resolveSourceLanguage(code string, lists *languageLists) (string, error) {
+	if lang, ok := findLanguage(code, lists.Source); ok {
+		return lang.Language, nil
+	}
+	if lang, ok := findLanguage(code, lists.Target); ok {
+		if lang, ok := findLanguage(baseLanguage(lang.Language), lists.Source); ok {
+			return lang.Language, nil
+		}
+	}
+	return "", unknownLanguage("source", code, lists.Source)
+}
+
+// Returns the target language code meant by code. Languages that DeepL only supports as targets in
+// some regional variant (EN and PT, whose plain codes are deprecated) get the one set in
+// target_variants, with a warning.
+func resolveTargetLanguage(code string, lists *languageLists, variants map[string]string, warn io.Writer) (string, error) {
+	if lang, ok := findLanguage(code, lists.Target); ok {
+		return lang.Language, nil
+	}
+	base, ok := findLanguage(code, lists.Source)
+	if !ok {
+		return "", unknownLanguage("target", code, lists.Target)
+	}
+	var regional []string
+	for _, lang := range lists.Target {
+		if baseLanguage(lang.Language) == base.Language {
+			regional = append(regional, lang.Language)
+		}
+	}
+	if len(regional) == 0 {
+		return "", fmt.Errorf("%s (%s) cannot be a target language; see `deepl-translate-cli languages --type target`", base.Language, base.Name)
+	}
+	for key, variant := range variants {
+		if !strings.EqualFold(key, base.Language) {
+			continue
+		}
+		if lang, ok := findLanguage(variant, lists.Target); ok {
+			fmt.Fprintf(warn, "warning: the target language %s is deprecated; using %s (%s) instead. Set the `target_variants.%s` setting to choose one of %s\n", base.Language, lang.Language, lang.Name, base.Language, strings.Join(regional, ", "))
+			return lang.Language, nil
+		}
+		return "", fmt.Errorf("target_variants.%s is set to %s, but the target language must be one of %s", base.Language, variant, strings.Join(regional, ", "))
+	}
+	return "", fmt.Errorf("the target language %s is deprecated; use one of %s (or set `target_variants.%s`)", base.Language, strings.Join(regional, ", "), base.Language)
+}
+
+// Checks the language pair of the settings against the languages supported by DeepL, replacing
+// aliases and names with the codes that DeepL expects. Without the lists of languages, the codes
+// are left as they are, for DeepL to check.
+func (s *Setting) resolveLanguages(lists *languageLists, warn io.Writer) error {
+	if lists == nil {
+		return nil
+	}
+	if s.SourceLang != "" {
+		code, err := resolveSourceLanguage(s.SourceLang, lists)
+		if err != nil {
+			return err
+		}
+		s.SourceLang = code
+	}
+	if s.TargetLang != "" {
+		code, err := resolveTargetLanguage(s.TargetLang, lists, s.TargetVariants, warn)
+		if err != nil {
+			return err
+		}
+		s.TargetLang = code
+	}
+	if baseLanguage(s.SourceLang) == baseLanguage(s.TargetLang) {
+		return fmt.Errorf("cannot translate from %s to %s, which are the same language", s.SourceLang, s.TargetLang)
+	}
+	return nil
+}
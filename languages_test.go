@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+)
+
+var testLanguages = &languageLists{
+	Source: []deepl.DeepLLanguagesResponse{
+		{Language: "DE", Name: "German"},
+		{Language: "EN", Name: "English"},
+		{Language: "JA", Name: "Japanese"},
+		{Language: "PT", Name: "Portuguese"},
+	},
+	Target: []deepl.DeepLLanguagesResponse{
+		{Language: "DE", Name: "German", SupportsFormality: true},
+		{Language: "EN-GB", Name: "English (British)"},
+		{Language: "EN-US", Name: "English (American)"},
+		{Language: "JA", Name: "Japanese", SupportsFormality: true},
+		{Language: "PT-BR", Name: "Portuguese (Brazilian)", SupportsFormality: true},
+		{Language: "PT-PT", Name: "Portuguese (European)", SupportsFormality: true},
+	},
+}
+
+func TestResolveLanguages(t *testing.T) {
+	tests := []struct {
+		source, target		string
+		expectedSource		string
+		expectedTarget		string
+		expectedError		string
+		expectedWarning		string
+	}{
+		{"en", "ja", "EN", "JA", "", ""},
+		{"japanese", "pt-br", "JA", "PT-BR", "", ""},
+		{"en-gb", "German", "EN", "DE", "", ""},
+		{"DE", "English (British)", "DE", "EN-GB", "", ""},
+		{"DE", "EN", "DE", "EN-US", "", "the target language EN is deprecated; using EN-US"},
+		{"DE", "portuguese", "DE", "PT-PT", "", "the target language PT is deprecated; using PT-PT"},
+		{"EN", "JP", "", "", `unknown target language "JP"; did you mean JA (Japanese)?`, ""},
+		{"Englsh", "DE", "", "", `unknown source language "Englsh"; did you mean EN (English)?`, ""},
+		{"EN", "XX-YY", "", "", `unknown target language "XX-YY"; see`, ""},
+		{"EN", "en-us", "", "", "cannot translate from EN to EN-US", ""},
+	}
+	for _, test := range tests {
+		setting := defaultSettings()
+		setting.SourceLang, setting.TargetLang = test.source, test.target
+		var warning bytes.Buffer
+		err := setting.resolveLanguages(testLanguages, &warning)
+		switch {
+			case test.expectedError != "":
+				if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+					t.Errorf("%s ⇒ %s: expected the error %q, got: %v", test.source, test.target, test.expectedError, err)
+				}
+			case err != nil:
+				t.Errorf("%s ⇒ %s: unexpected error: %s", test.source, test.target, err)
+			case setting.SourceLang != test.expectedSource || setting.TargetLang != test.expectedTarget:
+				t.Errorf("%s ⇒ %s: expected %s ⇒ %s, got %s ⇒ %s", test.source, test.target, test.expectedSource, test.expectedTarget, setting.SourceLang, setting.TargetLang)
+			case !strings.Contains(warning.String(), test.expectedWarning) || (test.expectedWarning == "") != (warning.Len() == 0):
+				t.Errorf("%s ⇒ %s: expected the warning %q, got %q", test.source, test.target, test.expectedWarning, warning.String())
+		}
+	}
+
+	// the regional default is configurable.
+	setting := defaultSettings()
+	setting.TargetVariants["EN"] = "en-gb"
+	setting.SourceLang, setting.TargetLang = "DE", "english"
+	if err := setting.resolveLanguages(testLanguages, &bytes.Buffer{}); err != nil || setting.TargetLang != "EN-GB" {
+		t.Errorf("Expected EN-GB, got %s (%v)", setting.TargetLang, err)
+	}
+	delete(setting.TargetVariants, "EN")
+	setting.TargetLang = "EN"
+	if err := setting.resolveLanguages(testLanguages, &bytes.Buffer{}); err == nil {
+		t.Errorf("Expected an error for EN without a regional default")
+	}
+
+	// without the lists, nothing is checked.
+	setting.TargetLang = "JP"
+	if err := setting.resolveLanguages(nil, &bytes.Buffer{}); err != nil || setting.TargetLang != "JP" {
+		t.Errorf("Expected JP to be left alone, got %s (%v)", setting.TargetLang, err)
+	}
+}
+
+func TestLanguagesCache(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	path, err := languagesCachePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lists, err := readLanguagesCache(filepath.Join(t.TempDir(), "missing.json")); lists != nil || err != nil {
+		t.Errorf("A missing cache should be empty, got %v (%v)", lists, err)
+	}
+	cached := *testLanguages
+	cached.Fetched = time.Now().Add(-time.Hour)
+	if err := writeLanguagesCache(path, &cached); err != nil {
+		t.Fatal(err)
+	}
+
+	// without a token, DeepL is never asked, so anything must come from the cache.
+	setting := defaultSettings()
+	if lists, err := supportedLanguages(setting); err != nil || lists == nil || len(lists.Target) != len(testLanguages.Target) {
+		t.Errorf("Expected the cached languages, got %v (%v)", lists, err)
+	}
+	// the languages command only looks for the token if it has to ask DeepL.
+	marker := filepath.Join(t.TempDir(), "token-command-ran")
+	setting.TokenCommand = "touch " + marker
+	if lists, err := listLanguages(&setting, false); err != nil || lists == nil {
+		t.Errorf("Expected the cached languages, got %v (%v)", lists, err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("The token should not be loaded while the cache is recent enough")
+	}
+	setting.TokenCommand = ""
+
+	setting.LanguagesTTL = "30m"
+	if lists, err := supportedLanguages(setting); err != nil || lists == nil {
+		t.Errorf("An outdated cache is better than nothing, got %v (%v)", lists, err)
+	}
+}
+
+func TestLanguageRows(t *testing.T) {
+	rows := languageRows(testLanguages)
+	var codes []string
+	for _, r := range rows {
+		codes = append(codes, r.Language+":"+r.use())
+	}
+	expected := "DE:source, target EN:source EN-GB:target EN-US:target JA:source, target PT:source PT-BR:target PT-PT:target"
+	if strings.Join(codes, " ") != expected {
+		t.Errorf("Expected: %s\nActual: %s", expected, strings.Join(codes, " "))
+	}
+
+	tests := []struct {
+		filter		languageFilter
+		expected	string
+	}{
+		{languageFilter{Type: "source"}, "DE EN JA PT"},
+		{languageFilter{Type: "target", Search: "portu"}, "PT-BR PT-PT"},
+		{languageFilter{Type: "all", Search: "en"}, "EN EN-GB EN-US"},
+		{languageFilter{Type: "all", Formality: true}, "DE JA PT-BR PT-PT"},
+		{languageFilter{Type: "source", Search: "xx"}, ""},
+	}
+	for _, test := range tests {
+		var actual []string
+		for _, r := range test.filter.apply(rows) {
+			actual = append(actual, r.Language)
+		}
+		if strings.Join(actual, " ") != test.expected {
+			t.Errorf("%+v\nExpected: %s\nActual: %s", test.filter, test.expected, strings.Join(actual, " "))
+		}
+	}
+
+	var b bytes.Buffer
+	if err := printLanguages(&b, languageFilter{Type: "all", Search: "german"}.apply(rows), "all", outputTable); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "DE  German  source, target  + formality\n"; b.String() != expected {
+		t.Errorf("Expected: %q\nActual: %q", expected, b.String())
+	}
+	b.Reset()
+	if err := printLanguages(&b, nil, "source", outputJSON); err != nil || strings.TrimSpace(b.String()) != "[]" {
+		t.Errorf("Expected an empty JSON array, got %q (%v)", b.String(), err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+)
+
+// One successful translation request (or its part for one input file), as recorded in the
+// local spend ledger.
+type ledgerRecord struct {
+	Time		time.Time	`json:"time"`
+	User		string		`json:"user,omitempty"`
+	Project		string		`json:"project,omitempty"`
+	SourceLang	string		`json:"source_lang,omitempty"`	// As detected by DeepL, if not given.
+	TargetLang	string		`json:"target_lang"`
+	File		string		`json:"file,omitempty"`			// Input file, or "-" for STDIN.
+	Characters	int			`json:"characters"`				// Billable characters.
+	Request		string		`json:"request,omitempty"`		// Shared by the records of the same request.
+}
+
+// Returns a new identifier for a request, to tell which ledger records belong to it.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Returns the user name to record in the ledger: the one from the settings, if any,
+// otherwise the login name.
+func ledgerUser(setting Setting) string {
+	if setting.User != "" {
+		return setting.User
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// The spend ledger is a JSON Lines file, appended to after every request, so that
+// concurrent invocations (even from different users, if it is shared) never clash.
+type spendLedger struct {
+	path	string
+}
+
+// Returns the default location for the spend ledger.
+func defaultLedgerPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ledger.jsonl"), nil
+}
+
+// Opens the spend ledger configured in the settings.
+func openLedger(setting Setting) (*spendLedger, error) {
+	path := setting.LedgerPath
+	if path == "" {
+		var err error
+		if path, err = defaultLedgerPath(); err != nil {
+			return nil, err
+		}
+	}
+	return &spendLedger{path: path}, nil
+}
+
+// Appends one record to the ledger.
+func (l *spendLedger) append(r ledgerRecord) error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write to the spend ledger: %s", err)
+	}
+	return nil
+}
+
+// Calls fn for every record in the ledger, oldest first; a missing ledger has no records.
+// Lines that cannot be parsed (e.g. truncated by a crash) are skipped.
+func (l *spendLedger) each(fn func(r ledgerRecord)) error {
+	f, err := os.Open(l.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r ledgerRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			if debugLevel > 0 {
+				fmt.Fprintf(os.Stderr, "skipping invalid ledger line %q: %s\n", scanner.Text(), err)
+			}
+			continue
+		}
+		fn(r)
+	}
+	return scanner.Err()
+}
@@ -8,14 +8,13 @@ import (
 	"io"
 	"log"
 	"os"
-	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/Omochice/deepl-translate-cli/deepl"
-	"github.com/lmorg/readline"
-	"github.com/mattn/go-isatty"
+	"github.com/gdamore/tcell/v2"
 	"github.com/urfave/cli/v2"
 )
 
@@ -100,16 +99,19 @@ func initVersionInfo() error {
 	return nil
 }
 
-// Internal settings, to be filled by LoadSettings(), and which gets saved to a file to
-// be reused on subsequent calls.
+// Internal settings, to be filled by LoadSettings() from the defaults, the settings files,
+// the environment and the command-line flags, in increasing order of precedence.
 // NOTE: This might become utterly different if we implement settings stored via
 // the github.com/urfave/cli-altsrc package. (gwyneth 20231103)
 type Setting struct {
 	AuthKey    			string	`json:"-"`						// API token, looks like a UUID with ":fx".
 	SourceLang 			string	`json:"source_lang"`
 	TargetLang 			string	`json:"target_lang"`
-	LanguagesType		string	`json:"type"`					// For the "languages" utility call, either "source" or "target".
-	IsPro      			bool	`json:"-"`
+	LanguagesType		string	`json:"type"`					// For the "languages" utility call, either "source", "target" or "all".
+	LanguagesTTL		string	`json:"languages_ttl"`			// How long the cached lists of languages are used, e.g. "24h"; "0" always asks DeepL.
+	TargetVariants		map[string]string	`json:"target_variants"`	// Regional variant used for deprecated target languages such as EN or PT.
+	IsPro      			bool	`json:"pro"`					// Always use the Pro plan's endpoint?
+	IsFree				bool	`json:"free"`					// Always use the Free plan's endpoint?
 	TagHandling			string	`json:"tag_handling"`			// "xml", "html".
 	SplitSentences		string	`json:"split_sentences"`		// "0", "1", "norewrite".
 	PreserveFormatting	string	`json:"preserve_formatting"`	// "0", "1".
@@ -117,75 +119,83 @@ type Setting struct {
 	NonSplittingTags	string	`json:"non_splitting_tags"`		// List of comma-separated XML tags.
 	SplittingTags		string	`json:"splitting_tags"`			// List of comma-separated XML tags.
 	IgnoreTags			string	`json:"ignore_tags"`			// List of comma-separated XML tags.
+	Formality			string	`json:"formality"`				// "default", "more", "less", "prefer_more", "prefer_less".
+	Glossary			string	`json:"glossary"`				// Glossary ID or name.
 	Debug				int		`json:"debug"`					// Debug/verbosity level, 0 is no debugging.
+	MemoryPath			string	`json:"memory_path"`			// Translation memory file; empty means the default location.
+	FuzzyThreshold		float64	`json:"fuzzy_threshold"`		// Minimum similarity (0-1) for fuzzy translation memory matches.
+	UseMemory			bool	`json:"memory"`					// Translate through the translation memory?
+	SegmentBy			string	`json:"segment"`				// "sentence", "paragraph", "none".
+	UseFuzzy			bool	`json:"use_fuzzy"`				// Use fuzzy matches as translations?
+	PreserveWhitespace	bool	`json:"preserve_whitespace"`	// Keep the whitespace and line endings of the input in translations?
+	Project				string	`json:"project"`				// Project tag for the spend ledger and the per-project budgets.
+	User				string	`json:"user"`					// User name for the spend ledger; empty means the login name.
+	LedgerPath			string	`json:"ledger_path"`			// Spend ledger file; empty means the default location.
+	HistoryPath			string	`json:"history_path"`			// History of the interactive mode; empty means the default location.
+	Budget				budgetSetting	`json:"budget"`		// Character budgets.
+	TokenEnv			string	`json:"token_env"`				// Environment variable with the API token.
+	TokenFile			string	`json:"token_file"`				// File with the API token, readable only by its owner.
+	TokenCommand		string	`json:"token_command"`			// Command whose output is the API token.
+	Keys				[]tokenSource	`json:"keys"`			// Pool of API tokens, used in turn as their quotas run out.
+	keyPool				*deepl.KeyPool							// Set up from Keys by loadAuthKey.
+	Profile				string	`json:"profile"`				// Profile to apply on top of the settings files.
+	Profiles			map[string]json.RawMessage	`json:"profiles"`	// Named sets of settings.
 }
 
-// Open the settings file, or, if it doesn't exist, create it first.
-// TODO: Probably change all this to use github.com/urfave/cli-altsrc instead.
-func LoadSettings(setting Setting, automake bool) (Setting, error) {
-	if setting.AuthKey == "" {
-		return setting, fmt.Errorf("no DeepL token is set; use the environment variable `DEEPL_TOKEN` to set it")
-	}
-
-	if setting.TargetLang == "" || setting.SourceLang == "" {
-		homeDir, err := os.UserHomeDir()
-		configPath := filepath.Join(homeDir, ".config", "deepl-translate-cli", "setting.json")
-		// if either is not set, load file.
-		if err != nil {
-			return setting, err
-		}
-
-		bytes, err := os.ReadFile(configPath)
-		if err != nil {
-			errStr := fmt.Errorf("settings file does not exist. %s\n\tIt was autogenerated, please edit it to reflect your preferences", configPath)
-			if automake {
-				err := InitializeConfigFile(configPath)
-				if err != nil {
-					return setting, err
-				}
-			}
-			return setting, errStr
-		}
-		if err := json.Unmarshal(bytes, &setting); err != nil {
-			return setting, fmt.Errorf("%s (occurred while loading `setting.json`)", err.Error())
-		}
-		if setting.SourceLang == "FILLIN" || setting.TargetLang == "FILLIN" {
-			return setting, fmt.Errorf("did write config file? (%s)", configPath)
-		}
-	}
-	if setting.SourceLang == setting.TargetLang {
-		return setting, fmt.Errorf("cannot have identical source lang(%s) and target lang(%s)", setting.SourceLang, setting.TargetLang)
-	}
-	return setting, nil
+// Returns the base endpoint: the one for the plan chosen with `pro` or `free`, or else the one
+// matching the API token (which must have been loaded already).
+func (s Setting) endpoint() string {
+	return deepl.GetEndpoint(s.isPro())
 }
 
-// Attempts to create the directory for the configuration file, with a minimalist configurztion if successful.
-// If creating the directory (or the file within) fails, then abort and return error.
-func InitializeConfigFile(ConfigPath string) error {
-	if err := os.MkdirAll(filepath.Dir(ConfigPath), 0755); err != nil {
-		return err
-	}
-
-	initSetting := Setting{
-		SourceLang: "FILLIN",
-		TargetLang: "FILLIN",
+// Is the Pro plan being used? With a pool of keys, that depends on the current key.
+func (s Setting) isPro() bool {
+	switch {
+		case s.keyPool != nil:
+			key, _ := s.keyPool.Current()
+			return key.Endpoint() == deepl.GetEndpoint(true)
+		case s.IsPro:
+			return true
+		case s.IsFree:
+			return false
+		default:
+			return !deepl.IsFreeKey(s.AuthKey)
 	}
+}
 
-	out, err := os.Create(ConfigPath)
-	if err != nil {
-		return err
+// Returns an error if there is no API token to authenticate with.
+func (s Setting) requireAuthKey() error {
+	if s.AuthKey == "" {
+		if s.TokenEnv != "" {
+			return fmt.Errorf("no DeepL token is set; use the environment variable `%s` to set it", s.TokenEnv)
+		}
+		return fmt.Errorf("no DeepL token is set; use the environment variable `DEEPL_TOKEN` to set it")
 	}
-	defer out.Close()
+	return nil
+}
 
-	decoded, err := json.MarshalIndent(initSetting, "", "  ")
-	if err != nil {
-		return err
-	}
+// Output formats for the commands that support --output.
+const (
+	outputTable	= "table"	// Human-readable.
+	outputJSON	= "json"
+)
 
-	if _, err := out.Write(([]byte)(decoded)); err != nil {
-		return fmt.Errorf("failed to write to config file: %s", err)
+// The --output flag, shared by all commands that can produce structured output.
+func outputFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Usage:   "`FORMAT` of the output, either `table` or `json`",
+		Value:   outputTable,
+		Action: func(c *cli.Context, v string) error {
+			switch v {
+				case outputTable, outputJSON:
+					return nil
+				default:
+					return fmt.Errorf("output must be either `table` or `json` (got: %s)", v)
+			}
+		},
 	}
-	return nil
 }
 
 // TODO: Try to use "github.com/urfave/cli/v3" in the future...
@@ -195,40 +205,18 @@ func InitializeConfigFile(ConfigPath string) error {
 func main() {
 	// Global settings for this cli app.
 	var setting Setting
-	// DeepL Token, usually coming from the environmant variable `DEEPL_TOKEN`.
-	var deeplToken string
 
 	// Set up the version/runtime/debug-related variables, and cache them:
 	initVersionInfo()
 
-	// Test if the authentication can work or not, depending if we got the token
-	// set as an environment variable.
-	deeplToken, ok := os.LookupEnv("DEEPL_TOKEN")
-	if !ok {
-		fmt.Fprintln(os.Stderr, "Please set first your DeepL authentication key using the environment variable DEEPL_TOKEN.")
-		os.Exit(1)	// NOTE: the cli.Exit() function cannot be used here, because cli is not initialized yet.
-		// return cli.Exit(fmt.Sprintln("Please set first your DeepL authentication key using the environment variable DEEPL_TOKEN."), 1)
-	}
-	// Generic error variable to work around scoping issues.
-	var err error
-	// Configure all settings from the very start, because we need the authkey & endpoint
-	// for all other calls, not just translations.
-	setting, err = LoadSettings(
-		Setting{
-			AuthKey: deeplToken,
-		},
-		true)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "cannot init settings, error was: %q", err)
-		os.Exit(1)
-		// return cli.Exit(fmt.Sprintf("cannot init settings, error was: %q", err), 1)
-	}
+	// Where each setting came from, for `config show --origin`.
+	var origins settingOrigins
 
 	// start app
 	app := &cli.App{
 		Name:      "deepl-translate-cli",
 		Usage:     "Translate sentences, using the DeepL API.",
-		UsageText: "deepl-translate-cli [-s|-t][--pro] trans [--tag_handling [xml|html]] <inputfile>\ndeepl-translate-cli usage\ndeepl-translate-cli languages [--type=[source|target]]\ndeepl-translate-cli glossary-language-pairs",
+		UsageText: "deepl-translate-cli [-s|-t][--pro] trans [--tag_handling [xml|html]] <inputfile>\ndeepl-translate-cli usage\ndeepl-translate-cli languages [--type=[source|target|all]]\ndeepl-translate-cli glossary-language-pairs [--from <lang>]",
 		Version: fmt.Sprintf(
 			"%s (rev %s) [%s %s %s] [build at %s by %s]",
 			versionInfo.version,
@@ -240,7 +228,6 @@ func main() {
 			versionInfo.builtBy,		// see note at the top...
 		),
 		DefaultCommand: "translate",	// to avoid brealing compatibility with earlier versions.
-		EnableBashCompletion: true,
 		Compiled: versionInfo.date,		// Converted from RFC333
 		Authors: []*cli.Author{
 			{
@@ -257,22 +244,31 @@ func main() {
 			&cli.StringFlag{
 				Name:    "source_lang",
 				Aliases: []string{"s"},
-				Usage:   "Set source language without using the settings file",
-				Value:	 "EN",
-				Destination:	&setting.SourceLang,
+				Usage:   "Set source language, overriding the settings files",
+				DefaultText: "EN",
 			},
 			&cli.StringFlag{
 				Name:    "target_lang",
 				Aliases: []string{"t"},
-				Usage:   "Set target language without using the settings file",
-				Value:	 "JA",
-				Destination:	&setting.TargetLang,
+				Usage:   "Set target language, overriding the settings files; translate also takes several, separated by commas (e.g. DE,FR,JA)",
+				DefaultText: "JA",
 			},
 			&cli.BoolFlag{
 				Name:    "pro",
-				Usage:   "Use Pro plan's endpoint?",
-				Value:   false,
-				Destination: &setting.IsPro,
+				Usage:   "Use the Pro plan's endpoint, whatever the API token looks like",
+			},
+			&cli.BoolFlag{
+				Name:    "free",
+				Usage:   "Use the Free plan's endpoint, whatever the API token looks like",
+			},
+			&cli.StringFlag{
+				Name:    "project",
+				Usage:   "Tag spending with `PROJECT`, for the ledger and the per-project budgets",
+			},
+			&cli.StringFlag{
+				Name:    "profile",
+				Aliases: []string{"P"},
+				Usage:   "Apply the settings of profile `NAME`, as defined in the settings files",
 			},
 			&cli.BoolFlag{
 				Name:	"debug",
@@ -281,250 +277,951 @@ func main() {
 				Count:	&debugLevel,
 			},
 		},
+		// Settings are only resolved once the flags are parsed, since these take precedence.
+		Before: func(c *cli.Context) error {
+			workDir, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			setting, origins, err = LoadSettings(workDir, envLayer(), flagLayer(c, globalFlagSettings))
+			if err != nil {
+				switch c.Args().First() {
+					case "config":
+						// `config` is how broken settings get fixed, so it must still work.
+						fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+					case completeCommandName:
+						// completion just offers less.
+					default:
+						return fmt.Errorf("cannot init settings, error was: %w", err)
+				}
+			}
+			debugLevel = setting.Debug
+			// the token is only looked up by the commands that talk to DeepL.
+			return nil
+		},
 		Commands: []*cli.Command{
 			{
 				Name:        "translate",
 				Aliases:     []string{"trans"},
 				Usage:       "Basic translation of a set of Unicode strings into another language",
-				Description: "Text to be translated.\nOnly UTF-8-encoded plain text is supported. May contain multiple sentences, but the total request body size must not exceed 128 KiB (128 · 1024 bytes).\nPlease split up your text into multiple	calls if it exceeds this limit.",
+				Description: "Text to be translated.\nOnly UTF-8-encoded plain text is supported. May contain multiple sentences; texts larger than the 128 KiB (128 · 1024 bytes) request limit are automatically split into several requests.\nSeveral files may be given; each one is translated in turn.",
+				ArgsUsage:   "[<inputfile>...]",
 				Category:	 "Translations",
 				Flags: []cli.Flag{
 					&cli.StringFlag{
 						Name:        "tag_handling",
 						Usage:       "Set to XML or HTML in order to do more advanced parsing (empty means just using the plain text variant)",
 						Aliases:     []string{"tag"},
-						Value:       "",
-						Destination:	&setting.TagHandling,
-						Action: func(c *cli.Context, v string) error {
-							switch v {
-								case "xml", "html":
-									return nil
-								default:
-									return fmt.Errorf("tag_handling must be either `xml` or `html` (got: %s)", v)
-							}
-						},
+						Action:      validateFlag("tag_handling"),
 					},
 					&cli.StringFlag{
 						Name:        "split_sentences",
 						Usage:       "Sets whether the translation engine should first split the input into sentences. For text translations where `tag_handling` is not set to `html`, the default value is `1`, meaning the engine splits on punctuation and on newlines.\nFor text translations where `tag_handling=html`, the default value is `nonewlines`, meaning the engine splits on punctuation only, ignoring newlines.\n\nThe use of `nonewlines` as the default value for text translations where `tag_handling=html` is new behavior that was implemented in November 2022, when HTML handling was moved out of beta.\n\nPossible values are:\n\n * `0` - no splitting at all, whole input is treated as one sentence\n * `1` (default when `tag_handling` is not set to `html`) - splits on punctuation and on newlines\n * `nonewlines` (default when `tag_handling=html`) - splits on punctuation only, ignoring newlines\n\nFor applications that send one sentence per text parameter, we recommend setting `split_sentences` to `0`, in order to prevent the engine from splitting the sentence unintentionally.\n\nPlease note that newlines will split sentences when `split_sentences=1`. We recommend cleaning files so they don't contain breaking sentences or setting the parameter `split_sentences` to `nonewlines`.",
 						Aliases:     []string{"split"},
-						Value:       "",	// NOTE: default value should depend on `tag_handling`.
-						Destination:	&setting.SplitSentences,
-						Action: func(c *cli.Context, v string) error {
-							switch v {
-								case "0", "1", "nonewlines":
-									return nil
-								default:
-									return fmt.Errorf("split_sentences can only be 0, 1, or `nonewlines` (got: %s)", v)
-							}
-						},
+						Action:      validateFlag("split_sentences"),	// NOTE: default value should depend on `tag_handling`.
 					},
 					&cli.StringFlag{
 						Name:        "preserve_formatting",
 						Usage:       "Sets whether the translation engine should respect the original formatting, even if it would usually correct some aspects. Possible values are:\n * `0` (default)\n * `1`\n\nThe formatting aspects affected by this setting include:\n * Punctuation at the beginning and end of the sentence\n * Upper/lower case at the beginning of the sentence",
 						Aliases:     []string{"preserve"},
-						Value:       "0",
-						Destination: &setting.PreserveFormatting,
-						Action: func(c *cli.Context, v string) error {
-							switch v {
-								case "0", "1":
-									return nil
-								default:
-									return fmt.Errorf("preserve_formatting can only be 0 or 1 (got: %s)", v)
-							}
-						},
+						DefaultText: "0",
+						Action:      validateFlag("preserve_formatting"),
 					},
 					&cli.IntFlag{
 						Name:        "outline_detection",
 						Usage:       "The automatic detection of the XML structure won't yield best results in all XML files. You can disable this automatic mechanism altogether by setting the `outline_detection` parameter to `false` and selecting the tags that should be considered structure tags. This will split sentences using the `splitting_tags` parameter.",
 						Aliases:     []string{"outline"},
-						Value:       0,
-						Destination: &setting.OutlineDetection,
+						DefaultText: "0",
 					},
 					&cli.StringFlag{
 						Name:        "non_splitting_tags",
 						Usage:       "Comma-separated list of XML tags which never split sentences.",
 						Aliases:     []string{"never"},
-						//Value:       [""],
-						Destination: &setting.NonSplittingTags,
 					},
 					&cli.StringFlag{
 						Name:        "splitting_tags",
 						Usage:       "Comma-separated list of XML tags which always cause splits.",
 						Aliases:     []string{"always"},
-						//Value:       [""],
-						Destination: &setting.SplittingTags,
 					},
 					&cli.StringFlag{
 						Name:        "ignore_tags",
 						Usage:       "Comma-separated list of XML tags which will always be ignored.",
 						Aliases:     []string{"ignore"},
-						//Value:       [""],
-						Destination: &setting.IgnoreTags,
+					},
+					&cli.StringFlag{
+						Name:        "formality",
+						Usage:       "Sets whether the translated text should lean towards formal or informal language. This feature currently only works for some target languages (see `languages --type target`). Possible values are:\n * `default` (default)\n * `more` - for a more formal language\n * `less` - for a more informal language\n * `prefer_more` - for a more formal language if available, otherwise fallback to default formality\n * `prefer_less` - for a more informal language if available, otherwise fallback to default formality",
+						DefaultText: "default",
+						Action:      validateFlag("formality"),
+					},
+					&cli.StringFlag{
+						Name:        "glossary",
+						Usage:       "Use the glossary with this `ID or name`; it must match the source and target languages, and the source language must be set.",
+					},
+					&cli.BoolFlag{
+						Name:        "dry-run",
+						Usage:       "Do not translate anything; just estimate the billable characters and check them against the remaining allowance",
+						Value:       false,
+						EnvVars:     []string{"DEEPL_DRY_RUN"},
+					},
+					&cli.BoolFlag{
+						Name:        "memory",
+						Usage:       "Use the local translation memory: segments translated before are reused, and new ones are remembered",
+						Aliases:     []string{"tm"},
+					},
+					&cli.StringFlag{
+						Name:        "segment",
+						Usage:       "How to split the input for the translation memory: `sentence`, `paragraph` or `none`",
+						DefaultText: segmentBySentence,
+						Action:      validateFlag("segment"),
+					},
+					&cli.Float64Flag{
+						Name:        "fuzzy-threshold",
+						Usage:       "Minimum similarity (between 0 and 1) for fuzzy translation memory matches to be reported",
+						DefaultText: fmt.Sprint(defaultFuzzyThreshold),
+						Action: func(c *cli.Context, v float64) error {
+							return validateSetting("fuzzy_threshold", fmt.Sprint(v))
+						},
+					},
+					&cli.BoolFlag{
+						Name:        "use-fuzzy",
+						Usage:       "Use fuzzy translation memory matches as translations, instead of just reporting them",
+					},
+					&cli.BoolFlag{
+						Name:        "preserve-whitespace",
+						Usage:       "Keep the leading and trailing whitespace, line endings (e.g. CRLF) and blank lines of the input in translations; with --preserve-whitespace=false, the text is printed as DeepL returns it, but the whitespace around the input (and between the segments it is split into) is still kept, since it is never sent",
+						DefaultText: "true",
+					},
+					&cli.StringFlag{
+						Name:        "output-template",
+						Usage:       "Write each translation into its own file, named after this `template`: {dir}, {name} and {ext} stand for the directory, name and extension of the input file (\".\", \"stdin\" and nothing for STDIN), {lang} and {LANG} for the target language, e.g. \"{dir}/{name}.{lang}{ext}\"",
+						Aliases:     []string{"O"},
+					},
+					&cli.BoolFlag{
+						Name:        "null",
+						Usage:       "The input is a series of texts, each ending with a NUL character (as with `find -print0`), which are translated independently; the translations end with NUL as well",
+						Aliases:     []string{"0"},
+					},
+					&cli.StringFlag{
+						Name:        "delimiter",
+						Usage:       "The input is a series of texts separated by this `string` (backslash escapes such as \\n are understood), which are translated independently; the translations are separated by it as well",
+					},
+					&cli.BoolFlag{
+						Name:        "stream",
+						Usage:       "Translate STDIN line by line as it arrives (e.g. from `tail -f`), instead of waiting for its end",
+					},
+					&cli.IntFlag{
+						Name:        "batch-size",
+						Usage:       "With --stream, send at most this many lines per request",
+						Value:       deepl.MaxTextsPerRequest,
+					},
+					&cli.DurationFlag{
+						Name:        "batch-window",
+						Usage:       "With --stream, how long a line may wait for others to be sent along with it",
+						Value:       defaultBatchWindow,
+					},
+					&cli.StringFlag{
+						Name:        "input-format",
+						Usage:       "`text` translates each input as a whole; jsonl reads one JSON record per line, with an \"id\", a \"text\" and optionally its own \"target_lang\", \"formality\", \"context\" and \"glossary\", and writes one JSON result per line",
+						Value:       inputFormatText,
+					},
+					&cli.BoolFlag{
+						Name:        "json",
+						Usage:       "Print a JSON object with the translations keyed by target language (and by input file, if there are several)",
 					},
 				},
 				Action: func(c *cli.Context) error {
-/*
-					if c.String("source_lang") != "" {
-						setting.SourceLang = c.String("source_lang")
-					}
-					if c.String("target_lang") != "" {
-						setting.TargetLang = c.String("target_lang")
-					}
-					if c.Bool("pro") {
-						setting.IsPro = true
-					}
-*/
 					// TODO(gwyneth): Create constants for debugging levels.
 					if debugLevel > 1 {
 						fmt.Fprintf(os.Stderr, "Number of args (Narg): %d, c.Args.Len(): %d\n", c.NArg(), c.Args().Len())
 					}
-					// The captured sentence for translation, unprocessed; it can come from different sources!
-					var rawSentence string
-					if c.NArg() == 0 {
-						// no filename path passed; read from STDIN (TTY or pipe)
-						if isatty.IsTerminal(os.Stdin.Fd()) {
-							// is not pipe (i.e. TTY)
-							// NOTE: This seems not to work very well...(gwyneth 20231101)
-							// fmt.Scan(&rawSentence)
-							// Replaced it by using a readline (from a library), but it might really be overkill, since
-							rl := readline.NewInstance()
-							rawSentence, err = rl.Readline()
-							if err != nil {
-								return err
+					// The captured text for translation, unprocessed; it can come from different sources!
+					// On a terminal, the text is typed in interactive mode instead.
+					stream := c.Bool("stream")
+					var jsonl bool
+					switch c.String("input-format") {
+						case inputFormatText:
+						case inputFormatJSONL:
+							jsonl = true
+							if stream || c.IsSet("output-template") || c.Bool("json") {
+								return fmt.Errorf("--input-format %s always writes JSON Lines to STDOUT", inputFormatJSONL)
 							}
-						} else {
-							// is pipe
-							pipeIn, err := io.ReadAll(os.Stdin)
+						default:
+							return fmt.Errorf("--input-format must be either `%s` or `%s` (got: %s)", inputFormatText, inputFormatJSONL, c.String("input-format"))
+					}
+					delimiter, err := recordDelimiter(c)
+					if err != nil {
+						return err
+					}
+					if jsonl && delimiter != "" {
+						return fmt.Errorf("--input-format %s already has one record per line", inputFormatJSONL)
+					}
+					interactive := !stream && !jsonl && delimiter == "" && isInteractive(c)
+					if interactive && c.Bool("dry-run") {
+						return fmt.Errorf("nothing to estimate; give the files to translate, or pipe the text in")
+					}
+					if stream {
+						switch {
+							case c.NArg() > 0:
+								return fmt.Errorf("--stream only reads from STDIN")
+							case c.Bool("dry-run"):
+								return fmt.Errorf("a stream cannot be estimated in advance")
+							case c.IsSet("output-template") || c.Bool("json"):
+								return fmt.Errorf("--stream writes the translations to STDOUT as they come")
+							case c.Int("batch-size") < 1 || c.Int("batch-size") > deepl.MaxTextsPerRequest:
+								return fmt.Errorf("--batch-size must be between 1 and %d", deepl.MaxTextsPerRequest)
+							case c.Duration("batch-window") <= 0:
+								return fmt.Errorf("--batch-window must be positive")
+						}
+					}
+					var inputs []translationInput
+					if !interactive && !stream {
+						if inputs, err = readInputs(c); err != nil {
+							return err
+						}
+					}
+
+					// command-line flags override whatever the settings say.
+					if err := applySettingLayers(&setting, origins, flagLayer(c, translateFlagSettings)); err != nil {
+						return err
+					}
+					// a dry run can still estimate without a token, just not check the remaining allowance.
+					ts, err := setupTranslation(&setting, c.Bool("dry-run"))
+					if err != nil {
+						return err
+					}
+					t := ts.t
+					multiple := len(ts.targets) > 1
+					if interactive {
+						if multiple {
+							return fmt.Errorf("interactive mode translates into one target language at a time")
+						}
+						path, err := historyPath(setting)
+						if err != nil {
+							return err
+						}
+						history, err := loadHistory(path)
+						if err != nil {
+							return err
+						}
+						r := repl{
+							session:	newSession(&setting, ts, os.Stderr),
+							read:		terminalLines(history),
+							out:		os.Stdout,
+						}
+						if err := r.run(); err != nil {
+							return err
+						}
+						if t.memory != nil {
+							return t.memory.save()
+						}
+						return nil
+					}
+
+					if stream {
+						if multiple {
+							return fmt.Errorf("--stream translates into one target language at a time")
+						}
+						s := streamer{t: t, size: c.Int("batch-size"), window: c.Duration("batch-window"), delimiter: delimiter, out: os.Stdout, interrupt: notifyInterrupt()}
+						if s.delimiter == "" {
+							s.delimiter = "\n"
+						}
+						return s.run(os.Stdin)
+					}
+
+					var plan *translationPlan
+					var run func() error	// Translates, once the plan has passed the checks.
+					if jsonl {
+						if multiple {
+							return fmt.Errorf("with --input-format %s, each record may set its own target language, but -t takes only one", inputFormatJSONL)
+						}
+						var names []string
+						var records []jsonlRecord
+						for _, input := range inputs {
+							parsed, err := parseJSONL(input)
 							if err != nil {
 								return err
 							}
-							rawSentence = string(pipeIn)
+							for range parsed {
+								names = append(names, input.Name)
+							}
+							records = append(records, parsed...)
+						}
+						groups, err := ts.planRecords(setting, names, records)
+						if err != nil {
+							return err
+						}
+						plan = combineGroups(groups)
+						run = func() error {
+							return translateRecords(os.Stdout, records, groups)
 						}
 					} else {
-						if c.NArg() >= 2 {
-							return fmt.Errorf("cannot specify multiple file paths")
+						template := c.String("output-template")
+						if template != "" && c.Bool("json") {
+							return fmt.Errorf("choose either --output-template or --json, not both")
+						}
+						if multiple && template == "" && !c.Bool("json") {
+							return fmt.Errorf("translating into %s needs either --output-template, to write each translation into its own file, or --json", strings.Join(ts.targets, ", "))
+						}
+						var paths [][]string
+						if template != "" {
+							if paths, err = outputPaths(template, inputs, ts.targets); err != nil {
+								return err
+							}
 						}
-						f, err := os.Open(c.Args().First())
+						translators, err := ts.translators()
 						if err != nil {
 							return err
 						}
-						b, err := io.ReadAll(f)
-						if err != nil {
+						// in record mode, each record is translated as if it were an input of its own.
+						records, counts := inputs, []int(nil)
+						if delimiter != "" {
+							records, counts = splitRecords(inputs, delimiter)
+						}
+						plans := make([]*translationPlan, len(translators))
+						estimates := make([]*translationPlan, len(translators))
+						for i, t := range translators {
+							if plans[i], err = t.plan(records); err != nil {
+								return err
+							}
+							estimates[i] = plans[i]
+							if counts != nil {
+								estimates[i] = joinRecordPlan(plans[i], inputs, counts)
+							}
+						}
+						plan = combinePlans(estimates, ts.targets)
+						run = func() error {
+							// Everything is passed via the DeepLClient initialisation; the translators just
+							// decide what to send, all at the same time.
+							outputs, err := executeAll(translators, plans)
+							for i := range outputs {
+								if counts != nil && outputs[i] != nil {
+									outputs[i] = joinRecords(outputs[i], counts, delimiter)
+								}
+							}
+							switch {
+								case paths != nil:
+									if err := writeOutputs(paths, outputs); err != nil {
+										return err
+									}
+								case c.Bool("json"):
+									if err := printTranslationsJSON(os.Stdout, inputs, ts.targets, outputs); err != nil {
+										return err
+									}
+								case err == nil:
+									printTranslations(os.Stdout, inputs, outputs[0], delimiter)
+							}
 							return err
 						}
-						rawSentence = string(b)
 					}
 
-					client := deepl.DeepLClient{
-						Endpoint: 			deepl.GetEndpoint(c.Bool("pro")) + "/translate",
-						AuthKey:			deeplToken,
-						SourceLang:			c.String("source_lang"),
-						TargetLang:			c.String("target_lang"),
-						LanguagesType:		c.String("type"),
-						IsPro:				c.Bool("pro"),
-						TagHandling:		c.String("tag_handling"),
-						SplitSentences:		c.String("split_sentences"),
-						PreserveFormatting:	c.String("preserve_formatting"),
-						OutlineDetection:	c.Int("outline_detection"),
-						NonSplittingTags:	c.String("non_splitting_tags"),
-						SplittingTags:		c.String("splitting_tags"),
-						IgnoreTags:			c.String("ignore_tags"),
-						Debug:				debugLevel,
+					if c.Bool("dry-run") {
+						var usage *deepl.DeepLUsageResponse
+						if setting.AuthKey == "" {
+							// already warned about.
+						} else if u, err := ts.usage(); err != nil {
+							fmt.Fprintf(os.Stderr, "Could not retrieve the remaining allowance: %s\n", err)
+						} else {
+							usage = &u
+						}
+						if err := printEstimate(os.Stdout, plan, usage); err != nil {
+							return cli.Exit(err, exitBudgetExceeded)
+						}
+						return t.guard.checkBudgets(plan.totalBillable())
 					}
 
-					// Simplified call to Translate, now everything is passed via the DeepLClient
-					// initialisation.
-					translateds, err := client.Translate(rawSentence)
+					// fail early, instead of translating only part of the input.
+					if err := t.guard.checkBudgets(plan.totalBillable()); err != nil {
+						return err
+					}
+					err = run()
+					if t.memory != nil {
+						if err := t.memory.save(); err != nil {
+							return err
+						}
+					}
+					return err
+				},
+			},
+			{
+				Name:        "tui",
+				Usage:       "Translate while typing, with the text and its translation side by side",
+				Description: "A full-screen editor: the text is translated again whenever the typing stops for a while.\nF2 and F3 change the source and target languages, F4 swaps them, F5 cycles through the formality values, and F6 changes the glossary; Esc quits.\nThe characters billed, and those remaining in the billing period, are shown below.",
+				Category:	 "Translations",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "formality",
+						Usage:       "Initial formality: `default`, `more`, `less`, `prefer_more` or `prefer_less`",
+						DefaultText: "default",
+						Action:      validateFlag("formality"),
+					},
+					&cli.StringFlag{
+						Name:        "glossary",
+						Usage:       "Initial glossary, by `ID or name`",
+					},
+					&cli.BoolFlag{
+						Name:        "memory",
+						Usage:       "Reuse translations from the local translation memory (which the drafts are not added to)",
+						Aliases:     []string{"tm"},
+					},
+					&cli.DurationFlag{
+						Name:        "debounce",
+						Usage:       "How long the typing must stop before translating",
+						Value:       defaultDebounce,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if err := applySettingLayers(&setting, origins, flagLayer(c, translateFlagSettings)); err != nil {
+						return err
+					}
+					ts, err := setupTranslation(&setting, false)
 					if err != nil {
 						return err
 					}
-					for _, translated := range translateds {
-						fmt.Print(translated)
+					if len(ts.targets) > 1 {
+						return fmt.Errorf("the tui translates into one target language at a time")
 					}
-					return nil
+					screen, err := tcell.NewScreen()
+					if err != nil {
+						return err
+					}
+					if err := screen.Init(); err != nil {
+						return err
+					}
+					u := newTUI(screen, newSession(&setting, ts, os.Stderr), ts.usage, c.Duration("debounce"))
+					err = u.run()
+					screen.Fini()
+					return err
 				},
 			},
 			{
 				Name:        "usage",
 				Aliases:     []string{"u"},
 				Usage:       "Check usage and limits",
-				Description: "Retrieve usage information within the current billing period together with the corresponding account limits.",
+				Description: "Retrieve usage information within the current billing period together with the corresponding account limits.\nWith --warn-at and --fail-at, the command exits with status 4 or 5, respectively, if any usage is at or above that percentage.",
 				Category:	 "Utilities",
+				Flags: []cli.Flag{
+					outputFlag(),
+					&cli.Float64Flag{
+						Name:  "warn-at",
+						Usage: "Exit with status 4 if usage is at or above `PERCENT`",
+					},
+					&cli.Float64Flag{
+						Name:  "fail-at",
+						Usage: "Exit with status 5 if usage is at or above `PERCENT`",
+					},
+				},
 				Action: func(c *cli.Context) error {
+					if err := setting.loadAuthKey(); err != nil {
+						return err
+					}
+					// with a pool of keys, each one is reported, and the thresholds apply to the total.
+					if setting.keyPool != nil {
+						keys, total := poolUsage(setting.keyPool, debugLevel)
+						rows := usageRows(total)
+						if err := printPoolUsage(os.Stdout, keys, rows, c.String("output")); err != nil {
+							return err
+						}
+						return checkUsageThresholds(rows, c.Float64("warn-at"), c.Float64("fail-at"))
+					}
 					client := deepl.DeepLClient{
-						Endpoint: deepl.GetEndpoint(c.Bool("pro")) + "/usage",
+						Endpoint: setting.endpoint() + "/usage",
 						AuthKey:  setting.AuthKey,
 					}
-					s, err := client.Usage()
+					usage, err := client.Usage()
 					if err != nil {
 						return err
 					}
-					fmt.Println(s)
-					return nil
+					rows := usageRows(usage)
+					if err := printUsage(os.Stdout, rows, c.String("output")); err != nil {
+						return err
+					}
+					return checkUsageThresholds(rows, c.Float64("warn-at"), c.Float64("fail-at"))
+				},
+			},
+			{
+				Name:        "config",
+				Usage:       "Create, inspect and change the settings",
+				Description: "Settings come from, in increasing order of precedence: the defaults, the user settings file ($XDG_CONFIG_HOME/deepl-translate-cli/setting.json, or ~/.config/deepl-translate-cli/setting.json), the first .deepl.json found from the working directory upwards, the selected profile, DEEPL_* environment variables, and command-line flags.\nKeys inside objects are given with dots, e.g. `budget.per_day` or `profiles.work.target_lang`.",
+				Category:	 "Utilities",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "init",
+						Usage: "Create a settings file, asking for the language pair and a few other basics",
+						Flags: append(configFileFlags(),
+							&cli.BoolFlag{
+								Name:  "force",
+								Usage: "Replace the settings file, if it already exists",
+							},
+							&cli.BoolFlag{
+								Name:    "yes",
+								Aliases: []string{"y"},
+								Usage:   "Do not ask anything; just write the default language pair",
+							},
+						),
+						Action: func(c *cli.Context) error {
+							path, err := configFilePath(c)
+							if err != nil {
+								return err
+							}
+							if _, err := os.Stat(path); err == nil && !c.Bool("force") {
+								return fmt.Errorf("%s already exists; change it with `config set`, or start over with --force", path)
+							}
+							if c.Bool("yes") {
+								if err := InitializeConfigFile(path); err != nil {
+									return err
+								}
+								fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+								return nil
+							}
+							// offer the languages DeepL actually supports, if it can be asked.
+							// without a token, only cached languages can be offered.
+							var sources, targets []deepl.DeepLLanguagesResponse
+							tokenErr := setting.loadAuthKey()
+							langs, err := supportedLanguages(setting)
+							switch {
+								case err != nil:
+									fmt.Fprintf(os.Stderr, "cannot retrieve the supported languages (%s); any language code will be accepted\n", err)
+								case langs == nil:
+									fmt.Fprintf(os.Stderr, "cannot retrieve the supported languages (%s); any language code will be accepted\n", tokenErr)
+								default:
+									sources, targets = langs.Source, langs.Target
+							}
+							values, err := askSettings(askFor(os.Stdin, os.Stderr), os.Stderr, sources, targets)
+							if err != nil {
+								return err
+							}
+							if err := writeSettingsFile(path, values); err != nil {
+								return err
+							}
+							fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+							return nil
+						},
+					},
+					{
+						Name:      "get",
+						Usage:     "Show the value of one setting: the effective one, or the one in a settings file with --local or --file",
+						ArgsUsage: "<key>",
+						Flags:     configFileFlags(),
+						Action: func(c *cli.Context) error {
+							if c.NArg() != 1 {
+								return fmt.Errorf("expected exactly one setting")
+							}
+							key := c.Args().First()
+							if !c.IsSet("file") && !c.Bool("local") {
+								raw, err := effectiveSetting(setting, key)
+								if err != nil {
+									return err
+								}
+								fmt.Println(formatSettingJSON(raw))
+								return nil
+							}
+							path, err := configFilePath(c)
+							if err != nil {
+								return err
+							}
+							values, err := readSettingsFile(path)
+							if err != nil {
+								return err
+							}
+							raw, ok := getSettingPath(values, splitSettingKey(key))
+							if !ok {
+								return cli.Exit(fmt.Sprintf("%s is not set in %s", key, path), 1)
+							}
+							fmt.Println(formatSettingJSON(raw))
+							return nil
+						},
+					},
+					{
+						Name:      "set",
+						Usage:     "Change one setting in a settings file (the user one, unless --local or --file are given)",
+						ArgsUsage: "<key> <value>",
+						Flags:     configFileFlags(),
+						Action: func(c *cli.Context) error {
+							if c.NArg() != 2 {
+								return fmt.Errorf("expected a setting and its value")
+							}
+							key, value := c.Args().Get(0), c.Args().Get(1)
+							path, err := configFilePath(c)
+							if err != nil {
+								return err
+							}
+							values, err := readSettingsFile(path)
+							if err != nil {
+								return err
+							}
+							raw, err := settingJSON(key, value)
+							if err != nil {
+								return err
+							}
+							keyPath := splitSettingKey(key)
+							if err := setSettingPath(values, keyPath, raw); err != nil {
+								return err
+							}
+							if err := checkSetting(keyPath[0], values[keyPath[0]]); err != nil {
+								return err
+							}
+							return writeSettingsFile(path, values)
+						},
+					},
+					{
+						Name:      "unset",
+						Usage:     "Remove one setting from a settings file (the user one, unless --local or --file are given)",
+						ArgsUsage: "<key>",
+						Flags:     configFileFlags(),
+						Action: func(c *cli.Context) error {
+							if c.NArg() != 1 {
+								return fmt.Errorf("expected exactly one setting")
+							}
+							key := c.Args().First()
+							path, err := configFilePath(c)
+							if err != nil {
+								return err
+							}
+							values, err := readSettingsFile(path)
+							if err != nil {
+								return err
+							}
+							if !unsetSettingPath(values, splitSettingKey(key)) {
+								return cli.Exit(fmt.Sprintf("%s is not set in %s", key, path), 1)
+							}
+							return writeSettingsFile(path, values)
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "List what is set in the settings files (see `config show` for the effective settings)",
+						Flags: configFileFlags(),
+						Action: func(c *cli.Context) error {
+							paths, err := configFilePaths(c)
+							if err != nil {
+								return err
+							}
+							return listSettingsFiles(os.Stdout, paths)
+						},
+					},
+					{
+						Name:  "path",
+						Usage: "Show the path of the user settings file, or of the project one with --local",
+						Flags: configFileFlags(),
+						Action: func(c *cli.Context) error {
+							path, err := configFilePath(c)
+							if err != nil {
+								return err
+							}
+							fmt.Println(path)
+							return nil
+						},
+					},
+					{
+						Name:  "validate",
+						Usage: "Check the settings files for syntax errors, unknown settings and invalid values",
+						Flags: configFileFlags(),
+						Action: func(c *cli.Context) error {
+							paths, err := configFilePaths(c)
+							if err != nil {
+								return err
+							}
+							count, err := validateSettingsFiles(os.Stdout, paths)
+							if err != nil {
+								return err
+							}
+							if count > 0 {
+								return cli.Exit(fmt.Sprintf("%d problem(s) found", count), 1)
+							}
+							return nil
+						},
+					},
+					{
+						Name:  "show",
+						Usage: "Show the effective settings",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "origin",
+								Usage: "Also show where each value came from",
+							},
+							outputFlag(),
+						},
+						Action: func(c *cli.Context) error {
+							return showSettings(os.Stdout, setting, origins, c.Bool("origin"), c.String("output"))
+						},
+					},
+				},
+			},
+			{
+				Name:        "stats",
+				Usage:       "Summarise spending from the local ledger",
+				Description: "Every successful translation request is recorded in a local ledger, with the user, the project (see --project), the languages, the input file and the billable characters. This command summarises it by day, project, language pair and user (or whatever is chosen with --by).",
+				Category:	 "Utilities",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "by",
+						Usage: "Comma-separated list of `DIMENSIONS` to summarise by: day, month, project, pair, user, file",
+						Value: strings.Join(defaultStatsDimensions, ","),
+					},
+					&cli.TimestampFlag{
+						Name:     "since",
+						Usage:    "Only count spending from `DATE` (YYYY-MM-DD) onwards",
+						Layout:   time.DateOnly,
+						Timezone: time.Local,
+					},
+					&cli.TimestampFlag{
+						Name:     "until",
+						Usage:    "Only count spending before `DATE` (YYYY-MM-DD)",
+						Layout:   time.DateOnly,
+						Timezone: time.Local,
+					},
+					&cli.StringFlag{
+						Name:  "only-project",
+						Usage: "Only count spending tagged with `PROJECT`",
+					},
+					&cli.StringFlag{
+						Name:  "only-user",
+						Usage: "Only count spending by `USER`",
+					},
+					outputFlag(),
+				},
+				Action: func(c *cli.Context) error {
+					ledger, err := openLedger(setting)
+					if err != nil {
+						return err
+					}
+					filter := statsFilter{
+						Project:	c.String("only-project"),
+						User:		c.String("only-user"),
+					}
+					if since := c.Timestamp("since"); since != nil {
+						filter.Since = *since
+					}
+					if until := c.Timestamp("until"); until != nil {
+						filter.Until = *until
+					}
+					var dimensions []string
+					for _, d := range strings.Split(c.String("by"), ",") {
+						if d = strings.TrimSpace(d); d != "" {
+							dimensions = append(dimensions, d)
+						}
+					}
+					summary, err := summariseLedger(ledger, dimensions, filter)
+					if err != nil {
+						return err
+					}
+					return printStats(os.Stdout, summary, dimensions, c.String("output"))
 				},
 			},
 			{
-				// TODO: make a call to languages and store the valid pairs retrieved,
-				// so that we can later validate them. (gwyneth 20231105)
 				Name:        "languages",
 				// Aliases:     []string{"l"},
 				Usage:       "Retrieve supported languages",
-				Description: "Retrieve the list of languages that are currently supported for translation, either as source or target language, respectively.",
+				Description: "Retrieve the list of languages that are currently supported for translation, either as source or target language, respectively.\nThe lists are cached for as long as the `languages_ttl` setting says.",
 				Category:	 "Utilities",
 				Flags: []cli.Flag{
 					&cli.StringFlag{
 						Name:        "type",
-						Usage:       "`TYPE` sets whether source or target languages should be listed. Possible options are:\n`source`: For languages that can be used in the `source_lang` parameter of translate requests.\n`target`: For languages that can be used in the `target_lang` parameter of translate requests.\n",
+						Usage:       "`TYPE` sets whether source or target languages should be listed. Possible options are:\n`source`: For languages that can be used in the `source_lang` parameter of translate requests.\n`target`: For languages that can be used in the `target_lang` parameter of translate requests.\n`all`: For both, saying where each language can be used.\n",
 						Value:       "source",
 						DefaultText: "source",
-						Action: func(c *cli.Context, v string) error {
-							switch v {
-								case "source", "target":
-									return nil
-								default:
-									return fmt.Errorf("type must be either `source` or `target` (got: %s)", v)
-							}
-						},
+						Action:      validateFlag("type"),
+					},
+					&cli.StringFlag{
+						Name:    "search",
+						Usage:   "Only list languages whose code or name contains `TEXT` (in any case)",
+					},
+					&cli.BoolFlag{
+						Name:    "supports-formality",
+						Usage:   "Only list target languages that support the formality option",
+					},
+					&cli.BoolFlag{
+						Name:    "refresh",
+						Usage:   "Ask DeepL, even if the cached lists are recent enough",
 					},
+					outputFlag(),
 				},
 				Action: func(c *cli.Context) error {
-					client := deepl.DeepLClient{
-						Endpoint:		deepl.GetEndpoint(c.Bool("pro")) + "/languages",
-						AuthKey:  		setting.AuthKey,
-						LanguagesType:	c.String("type"),
-					}
-					s, err := client.Languages()
+					langs, err := listLanguages(&setting, c.Bool("refresh"))
 					if err != nil {
 						return err
 					}
-					fmt.Println(s)
+					filter := languageFilter{
+						Type:		setting.LanguagesType,
+						Search:		c.String("search"),
+						Formality:	c.Bool("supports-formality"),
+					}
+					if c.IsSet("type") || filter.Type == "" {
+						filter.Type = c.String("type")
+					}
+					return printLanguages(os.Stdout, filter.apply(languageRows(langs)), filter.Type, c.String("output"))
+				},
+			},
+			{
+				Name:        "completion",
+				Usage:       "Print a shell completion script",
+				Description: "Prints the script that enables completion for the given shell, e.g.:\n\n   source <(deepl-translate-cli completion bash)\n\nCompletions are worked out as you type: commands, flags and their values, including language codes (from the cached lists), profiles and glossary names.",
+				ArgsUsage:   "bash|zsh|fish|powershell",
+				Category:	 "Utilities",
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return fmt.Errorf("expected exactly one shell: %s", strings.Join(completionShells, ", "))
+					}
+					return writeCompletionScript(os.Stdout, c.App.Name, c.Args().First())
+				},
+			},
+			{
+				Name:            completeCommandName,
+				Usage:           "Complete the words typed so far; used by the completion scripts",
+				Hidden:          true,
+				SkipFlagParsing: true,
+				Action: func(c *cli.Context) error {
+					// the first word is the program itself.
+					words := c.Args().Tail()
+					if len(words) == 0 {
+						words = []string{""}
+					}
+					printCompletions(os.Stdout, completeWords(c.App, setting, words))
 					return nil
 				},
 			},
+			{
+				Name:        "tm",
+				Usage:       "Query, import and export the local translation memory",
+				Description: "The translation memory stores every segment translated with `translate --memory`, so that it never gets sent (and billed) twice.",
+				Category:	 "Translation memory",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "query",
+						Usage:     "Look up a segment in the translation memory",
+						ArgsUsage: "<segment>",
+						Flags: []cli.Flag{
+							&cli.Float64Flag{
+								Name:  "fuzzy-threshold",
+								Usage: "Minimum similarity (between 0 and 1) for matches to be listed",
+								Value: defaultFuzzyThreshold,
+							},
+							&cli.IntFlag{
+								Name:  "limit",
+								Usage: "Maximum number of matches to list",
+								Value: 5,
+							},
+						},
+						Action: func(c *cli.Context) error {
+							if c.NArg() != 1 {
+								return fmt.Errorf("expected exactly one segment to look up")
+							}
+							memory, err := openMemory(setting)
+							if err != nil {
+								return err
+							}
+							matches := memory.fuzzy(setting.SourceLang, setting.TargetLang, c.Args().First(), c.Float64("fuzzy-threshold"), c.Int("limit"))
+							if len(matches) == 0 {
+								return fmt.Errorf("no matches found for %s ⇒ %s", setting.SourceLang, setting.TargetLang)
+							}
+							for _, match := range matches {
+								fmt.Printf("%3.0f%% [%s] %s\n     ⇒ %s\n", match.Score*100, match.Entry.Origin, match.Entry.Source, match.Entry.Target)
+							}
+							return nil
+						},
+					},
+					{
+						Name:        "import",
+						Usage:       "Import segment pairs into the translation memory",
+						Description: "Imports a file written by `tm export` (JSON or TMX). Translations that differ from the ones in the memory are corrections, and will always be used instead of DeepL's output.",
+						ArgsUsage:   "<file>",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "format",
+								Usage: "`FORMAT` of the file, either `json` or `tmx` (default: guessed from the file extension)",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							if c.NArg() != 1 {
+								return fmt.Errorf("expected exactly one file to import")
+							}
+							memory, err := openMemory(setting)
+							if err != nil {
+								return err
+							}
+							n, err := memory.importFile(c.Args().First(), c.String("format"))
+							if err != nil {
+								return err
+							}
+							fmt.Fprintf(os.Stderr, "Imported %d segment pairs.\n", n)
+							return memory.save()
+						},
+					},
+					{
+						Name:  "export",
+						Usage: "Export the translation memory",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "output",
+								Aliases: []string{"o"},
+								Usage:   "Write to `FILE` instead of STDOUT",
+							},
+							&cli.StringFlag{
+								Name:  "format",
+								Usage: "`FORMAT` of the output, either `json` or `tmx` (for CAT tools)",
+								Value: memoryFormatJSON,
+								Action: func(c *cli.Context, v string) error {
+									switch v {
+										case memoryFormatJSON, memoryFormatTMX:
+											return nil
+										default:
+											return fmt.Errorf("format must be either `json` or `tmx` (got: %s)", v)
+									}
+								},
+							},
+						},
+						Action: func(c *cli.Context) error {
+							memory, err := openMemory(setting)
+							if err != nil {
+								return err
+							}
+							out := io.Writer(os.Stdout)
+							if c.String("output") != "" {
+								f, err := os.Create(c.String("output"))
+								if err != nil {
+									return err
+								}
+								defer f.Close()
+								out = f
+							}
+							return memory.export(out, c.String("format"))
+						},
+					},
+				},
+			},
 			{
 				Name:        "glossary-language-pairs",
 				// Aliases:     []string{"l"},
 				Usage:       "List language pairs supported by glossaries",
 				Description: "Retrieve the list of language pairs supported by the glossary feature.",
 				Category:	 "Glossary",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "from",
+						Usage:   "Only list the pairs whose source language is `LANG`",
+					},
+					outputFlag(),
+				},
 				Action: func(c *cli.Context) error {
+					if err := setting.loadAuthKey(); err != nil {
+						return err
+					}
 					client := deepl.DeepLClient{
-						Endpoint:	deepl.GetEndpoint(c.Bool("pro")) + "/glossary-language-pairs",
+						Endpoint:	setting.endpoint() + "/glossary-language-pairs",
 						AuthKey:	setting.AuthKey,
+						Pool:		setting.keyPool,
 					}
-					s, err := client.GlossaryLanguagePairs()
+					pairs, err := client.GlossaryLanguagePairs()
 					if err != nil {
 						return err
 					}
-					fmt.Println(s)
-					return nil
+					return printGlossaryPairs(os.Stdout, glossaryPairsFrom(pairs, c.String("from")), c.String("output"))
 				},
 			},
 		},
 	}
-	err = app.Run(os.Args)
-	if err != nil {
+	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
 	}
 }
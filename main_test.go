@@ -1,62 +1,174 @@
 package main
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 )
 
 func TestLoadsettings(t *testing.T) {
-	var setting Setting
 	var actual Setting
+	var origins settingOrigins
 	var err error
 	var errorText string
 
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	workDir := t.TempDir()
+	configPath := filepath.Join(configHome, "deepl-translate-cli", "setting.json")
+
 	//
-	errorText = "The function should not overload SourceLang / TargetLang if either is not set."
-	setting = Setting{
-		AuthKey:    "test",
-		SourceLang: "EN",
-		TargetLang: "JA",
-		IsPro:      false,
-	}
-	actual, err = LoadSettings(setting, false)
+	errorText = "The function should fall back to the defaults if there are no settings files."
+	actual, origins, err = LoadSettings(workDir)
 	if err != nil {
 		t.Fatalf(errorText + "\n%#v", err)
 	}
-	if setting.AuthKey != actual.AuthKey ||
-		setting.SourceLang != actual.SourceLang ||
-		setting.TargetLang != actual.TargetLang {
-		t.Fatalf(errorText + "\nExpected: %#v\nActual: %#v", setting, actual)
+	if actual.SourceLang != "EN" || actual.TargetLang != "JA" || origins["target_lang"] != originDefault {
+		t.Fatalf(errorText + "\nActual: %#v", actual)
+	}
+	if Exists(configPath) {
+		t.Fatalf(errorText + "\nThe settings file should not have been created: %s", configPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configPath, []byte(`{"source_lang": "DE", "target_lang": "FR", "project": "user"}`), 0644); err != nil {
+		t.Fatal(err)
 	}
 
 	//
-	errorText = "There should occur an error on this function if AuthKey is not set."
-	expectedErrorText := "no DeepL token is set; use the environment variable `DEEPL_TOKEN` to set it" // DRY...
-	setting = Setting{
-		AuthKey:    "",
-		SourceLang: "EN",
-		TargetLang: "JA",
-		IsPro:      false,
+	errorText = "The function should not overload SourceLang / TargetLang if they are set on the command line."
+	flags := newSettingLayer()
+	flags.values["source_lang"], flags.origins["source_lang"] = "EN", "flag --source_lang"
+	flags.values["target_lang"], flags.origins["target_lang"] = "JA", "flag --target_lang"
+	actual, origins, err = LoadSettings(workDir, flags)
+	if err != nil {
+		t.Fatalf(errorText + "\n%#v", err)
+	}
+	if actual.SourceLang != "EN" || actual.TargetLang != "JA" || actual.Project != "user" {
+		t.Fatalf(errorText + "\nActual: %#v", actual)
+	}
+	if origins["target_lang"] != "flag --target_lang" || origins["project"] != "user file "+configPath || origins["tag_handling"] != originDefault {
+		t.Fatalf("Unexpected origins: %#v", origins)
+	}
+
+	//
+	errorText = "Each layer should override the previous one: user file, project file, environment, flags."
+	projectPath := filepath.Join(workDir, ".deepl.json")
+	if err := os.WriteFile(projectPath, []byte(`{"target_lang": "ES", "project": "project", "debug": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	subDir := filepath.Join(workDir, "some", "sub", "dir")
+	if err := os.MkdirAll(subDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DEEPL_TARGET_LANG", "IT")
+	actual, origins, err = LoadSettings(subDir, envLayer())
+	if err != nil {
+		t.Fatalf(errorText + "\n%#v", err)
+	}
+	if actual.SourceLang != "DE" || actual.TargetLang != "IT" || actual.Project != "project" || actual.Debug != 1 {
+		t.Fatalf(errorText + "\nActual: %#v", actual)
+	}
+	if origins["target_lang"] != "environment DEEPL_TARGET_LANG" || origins["project"] != "project file "+projectPath {
+		t.Fatalf(errorText + "\nUnexpected origins: %#v", origins)
+	}
+
+	//
+	errorText = "Translate options should be read from settings files, and flags should override them."
+	if err := os.WriteFile(projectPath, []byte(`{"formality": "less", "memory": true, "segment": "paragraph"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	actual, origins, err = LoadSettings(workDir)
+	if err != nil {
+		t.Fatalf(errorText + "\n%#v", err)
+	}
+	if actual.Formality != "less" || !actual.UseMemory || actual.SegmentBy != segmentByParagraph {
+		t.Fatalf(errorText + "\nActual: %#v", actual)
+	}
+	translateFlags := newSettingLayer()
+	translateFlags.values["formality"], translateFlags.origins["formality"] = "more", "flag --formality"
+	if err := applySettingLayers(&actual, origins, translateFlags); err != nil {
+		t.Fatalf(errorText + "\n%#v", err)
+	}
+	if actual.Formality != "more" || origins["formality"] != "flag --formality" {
+		t.Fatalf(errorText + "\nActual: %#v", actual)
+	}
+	translateFlags.values["formality"] = "very"
+	if err := applySettingLayers(&actual, origins, translateFlags); err == nil {
+		t.Fatalf(errorText + "\nAn invalid formality should be rejected")
+	}
+	if err := os.WriteFile(projectPath, []byte(`{"split_sentences": "2"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = LoadSettings(workDir); err == nil {
+		t.Fatalf(errorText + "\nAn invalid split_sentences in a settings file should be rejected")
+	}
+	if err := os.Remove(projectPath); err != nil {
+		t.Fatal(err)
+	}
+
+	//
+	errorText = "A profile should override the settings files, but not the environment or the flags."
+	if err := os.WriteFile(projectPath, []byte(`{"profiles": {"work": {"pro": true, "target_lang": "DE", "source_lang": "EN"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DEEPL_PROFILE", "work")
+	actual, origins, err = LoadSettings(workDir, envLayer())
+	if err != nil {
+		t.Fatalf(errorText + "\n%#v", err)
 	}
-	actual, err = LoadSettings(setting, false)
+	if actual.TargetLang != "IT" || actual.SourceLang != "EN" || !actual.IsPro {
+		t.Fatalf(errorText + "\nActual: %#v", actual)
+	}
+	if origins["pro"] != "profile work" || origins["profile"] != "environment DEEPL_PROFILE" {
+		t.Fatalf(errorText + "\nUnexpected origins: %#v", origins)
+	}
+	t.Setenv("DEEPL_PROFILE", "play")
+	if _, _, err = LoadSettings(workDir, envLayer()); err == nil {
+		t.Fatalf(errorText + "\nAn unknown profile should be rejected")
+	}
+	t.Setenv("DEEPL_PROFILE", "")
+
+	//
+	errorText = "A project file should not be able to say where the token comes from, nor which files are used."
+	for _, data := range []string{
+		`{"token_command": "echo gotcha"}`,
+		`{"keys": [{"token_command": "echo gotcha"}]}`,
+		`{"profiles": {"work": {"token_file": "/etc/passwd"}}}`,
+		`{"ledger_path": "../../.bashrc"}`,
+		`{"profiles": {"work": {"memory_path": "/tmp/memory.json"}}}`,
+	} {
+		if err := os.WriteFile(projectPath, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = LoadSettings(workDir); err == nil || !strings.Contains(err.Error(), "cannot be set in a project file") {
+			t.Fatalf(errorText + "\n%s was accepted: %v", data, err)
+		}
+	}
+	if err := os.Remove(projectPath); err != nil {
+		t.Fatal(err)
+	}
+
+	//
+	errorText = "There should occur an error if AuthKey is not set."
+	expectedErrorText := "no DeepL token is set; use the environment variable `DEEPL_TOKEN` to set it" // DRY...
+	err = Setting{SourceLang: "EN", TargetLang: "JA"}.requireAuthKey()
 	if err == nil {
-		t.Fatalf(errorText+"\nReturned: %#v", actual)
+		t.Fatalf(errorText)
 	} else if err.Error() != expectedErrorText {
 		t.Fatalf(errorText+"\nExpected: %s\nActual: %s", expectedErrorText, err)
 	}
 
 	//
 	errorText = "There should occur an error on this function if SourceLang == TargetLang"
-	setting = Setting{
-		AuthKey:    "test",
-		SourceLang: "EN",
-		TargetLang: "EN",
-		IsPro:      false,
-	}
-	actual, err = LoadSettings(setting, false)
+	flags.values["target_lang"] = "EN"
+	actual, _, err = LoadSettings(workDir, flags)
 	if err == nil {
-		t.Fatalf(errorText+"\nInput: %#v", setting)
+		t.Fatalf(errorText+"\nResult: %#v", actual)
 	}
 }
 
@@ -104,3 +216,100 @@ func TestInitializeConfigFile(t *testing.T) {
 		t.Fatalf("The function should have created the config file: %q", p)
 	}
 }
+
+func TestLoadAuthKey(t *testing.T) {
+	t.Setenv("DEEPL_TOKEN", "from-env")
+	t.Setenv("DEEPL_WORK_TOKEN", "from-work-env")
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name		string
+		setting		Setting
+		expected	string
+	}{
+		{"DEEPL_TOKEN is the fallback", Setting{}, "from-env"},
+		{"token_env", Setting{TokenEnv: "DEEPL_WORK_TOKEN"}, "from-work-env"},
+		{"token_file", Setting{TokenFile: tokenPath}, "from-file"},
+		{"token_command", Setting{TokenCommand: "echo from-command"}, "from-command"},
+	}
+	for _, test := range tests {
+		if err := test.setting.loadAuthKey(); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		} else if test.setting.AuthKey != test.expected {
+			t.Errorf("%s\nExpected: %s\nActual: %s", test.name, test.expected, test.setting.AuthKey)
+		}
+	}
+
+	if err := os.Chmod(tokenPath, 0644); err != nil {
+		t.Fatal(err)
+	}
+	setting := Setting{TokenFile: tokenPath}
+	if err := setting.loadAuthKey(); err == nil && runtime.GOOS != "windows" {
+		t.Errorf("A token file readable by others should be refused")
+	}
+	setting = Setting{TokenEnv: "DEEPL_UNSET_TOKEN"}
+	if err := setting.loadAuthKey(); err == nil {
+		t.Errorf("A missing token should be an error")
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	tests := []struct {
+		setting		Setting
+		expected	string
+	}{
+		{Setting{AuthKey: "0000-0000:fx"}, "https://api-free.deepl.com/v2"},
+		{Setting{AuthKey: "0000-0000"}, "https://api.deepl.com/v2"},
+		{Setting{AuthKey: "0000-0000:fx", IsPro: true}, "https://api.deepl.com/v2"},
+		{Setting{AuthKey: "0000-0000", IsFree: true}, "https://api-free.deepl.com/v2"},
+	}
+	for _, test := range tests {
+		if actual := test.setting.endpoint(); actual != test.expected {
+			t.Errorf("Key %s (pro: %v, free: %v)\nExpected: %s\nActual: %s", test.setting.AuthKey, test.setting.IsPro, test.setting.IsFree, test.expected, actual)
+		}
+		if pro := test.setting.isPro(); pro != (test.expected == "https://api.deepl.com/v2") {
+			t.Errorf("Key %s (pro: %v, free: %v) should report the plan of %s, not pro: %v", test.setting.AuthKey, test.setting.IsPro, test.setting.IsFree, test.expected, pro)
+		}
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("DEEPL_TAG_HANDLING", "html")
+	t.Setenv("DEEPL_FUZZY_THRESHOLD", "0.9")
+	t.Setenv("DEEPL_BUDGET", `{"per_day": 1000}`)
+	t.Setenv("DEEPL_TARGET_LANGUAGE", "DE")
+	t.Setenv("DEEPL_TOKEN", "0000-0000-1234:fx")
+
+	setting, origins, err := LoadSettings(t.TempDir(), envLayer())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if setting.TagHandling != "html" || setting.FuzzyThreshold != 0.9 || setting.Budget.PerDay != 1000 || setting.TargetLang != "JA" {
+		t.Errorf("Every setting should be read from its DEEPL_* variable\nActual: %#v", setting)
+	}
+	if origins["tag_handling"] != "environment DEEPL_TAG_HANDLING" {
+		t.Errorf("Unexpected origin: %s", origins["tag_handling"])
+	}
+
+	t.Setenv("DEEPL_TAG_HANDLING", "json")
+	if _, _, err := LoadSettings(t.TempDir(), envLayer()); err == nil {
+		t.Errorf("An invalid value in the environment should be rejected")
+	}
+
+	var b bytes.Buffer
+	if err := showEnvironment(&b, activeEnvironment(setting)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, expected := range []string{"DEEPL_TARGET_LANGUAGE", "unknown; ignored", "setting fuzzy_threshold", "…4:fx"} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("Expected %q in the list of variables:\n%s", expected, b.String())
+		}
+	}
+	if strings.Contains(b.String(), "0000-0000") {
+		t.Errorf("Tokens should be masked:\n%s", b.String())
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Where a translation memory entry came from.
+const (
+	originMachine = "mt"    // Translated by DeepL.
+	originHuman   = "human" // Imported or corrected by a person; always wins over machine output.
+)
+
+// Default similarity (between 0 and 1) above which fuzzy matches are considered.
+const defaultFuzzyThreshold = 0.85
+
+// One source/target segment pair stored in the translation memory.
+// The same source may have several entries, one for each set of options that change the translation.
+type memoryEntry struct {
+	SourceLang	string		`json:"source_lang"`
+	TargetLang	string		`json:"target_lang"`
+	Formality	string		`json:"formality,omitempty"`
+	GlossaryID	string		`json:"glossary_id,omitempty"`
+	Context		string		`json:"context,omitempty"`
+	TagHandling	string		`json:"tag_handling,omitempty"`
+	Source		string		`json:"source"`
+	Target		string		`json:"target"`
+	Origin		string		`json:"origin"`	// "mt" or "human".
+	Created		time.Time	`json:"created"`
+	Updated		time.Time	`json:"updated"`
+}
+
+// A match found in the translation memory; Score is 1 for exact matches.
+type memoryMatch struct {
+	Entry	memoryEntry
+	Score	float64
+}
+
+// Local segment-level translation memory, persisted as a JSON file.
+type translationMemory struct {
+	Entries	[]memoryEntry	`json:"entries"`
+
+	path	string			// File where the memory is saved.
+	index	map[string]int	// Maps languages + options + source segment to the position in Entries.
+	dirty	bool			// Has anything changed since loading?
+	mu		sync.Mutex		// Guards lookups and additions, which may come from concurrent translations.
+}
+
+// Returns the default location for the translation memory file.
+func defaultMemoryPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "memory.json"), nil
+}
+
+// Loads the translation memory from path; a missing file is just an empty memory.
+func loadMemory(path string) (*translationMemory, error) {
+	m := &translationMemory{path: path}
+	bytes, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(bytes, m); err != nil {
+			return nil, fmt.Errorf("%s (occurred while loading translation memory %s)", err.Error(), path)
+		}
+	}
+	m.reindex()
+	return m, nil
+}
+
+// Opens the translation memory configured in the settings.
+func openMemory(setting Setting) (*translationMemory, error) {
+	path := setting.MemoryPath
+	if path == "" {
+		var err error
+		if path, err = defaultMemoryPath(); err != nil {
+			return nil, err
+		}
+	}
+	return loadMemory(path)
+}
+
+// Writes the translation memory back to disk, but only if something has changed.
+func (m *translationMemory) save() error {
+	if !m.dirty {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first, so that an interrupted run never leaves a truncated memory behind.
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0644); err != nil {
+		return fmt.Errorf("failed to write translation memory: %s", err)
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return err
+	}
+	m.dirty = false
+	return nil
+}
+
+// Writes all entries, either as JSON (the same format used to store the memory) or as TMX.
+func (m *translationMemory) export(w io.Writer, format string) error {
+	switch format {
+		case memoryFormatJSON, "":
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(m)
+		case memoryFormatTMX:
+			return m.exportTMX(w)
+		default:
+			return fmt.Errorf("format must be either `json` or `tmx` (got: %s)", format)
+	}
+}
+
+// Imports entries from a JSON file previously written by export, or from a TMX file;
+// if format is empty, it is guessed from the file extension.
+// Entries without an origin are human translations; so are entries whose target differs
+// from what the memory has, since those are corrections made outside this tool.
+// Returns the number of entries that were added or changed.
+func (m *translationMemory) importFile(path string, format string) (int, error) {
+	if format == "" {
+		format = memoryFormatJSON
+		if strings.EqualFold(filepath.Ext(path), ".tmx") {
+			format = memoryFormatTMX
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var entries []memoryEntry
+	switch format {
+		case memoryFormatJSON:
+			var imported translationMemory
+			if err := json.NewDecoder(f).Decode(&imported); err != nil {
+				return 0, fmt.Errorf("%s (occurred while importing %s)", err.Error(), path)
+			}
+			entries = imported.Entries
+		case memoryFormatTMX:
+			if entries, err = parseTMX(f); err != nil {
+				return 0, fmt.Errorf("%s (occurred while importing %s)", err.Error(), path)
+			}
+		default:
+			return 0, fmt.Errorf("format must be either `json` or `tmx` (got: %s)", format)
+	}
+
+	n := 0
+	for _, e := range entries {
+		if e.Source == "" || e.Target == "" {
+			continue
+		}
+		if e.Origin == "" {
+			e.Origin = originHuman
+		}
+		if old, ok := m.find(e); ok && old.Target != e.Target {
+			e.Origin = originHuman
+			e.Updated = time.Time{}
+		}
+		if m.add(e) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func memoryKey(e memoryEntry) string {
+	return e.scope() + "\x00" + e.Source
+}
+
+// Languages and options of an entry: only entries with the same scope are translations of each other.
+func (e memoryEntry) scope() string {
+	return strings.Join([]string{
+		strings.ToUpper(e.SourceLang),
+		strings.ToUpper(e.TargetLang),
+		e.Formality,
+		e.GlossaryID,
+		e.Context,
+		e.TagHandling,
+	}, "\x00")
+}
+
+func (m *translationMemory) reindex() {
+	m.index = make(map[string]int, len(m.Entries))
+	for i, e := range m.Entries {
+		m.index[memoryKey(e)] = i
+	}
+}
+
+// Stores a segment pair. Machine translations never overwrite human ones.
+// Returns true if the memory was changed.
+func (m *translationMemory) add(e memoryEntry) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	if e.Created.IsZero() {
+		e.Created = now
+	}
+	if e.Updated.IsZero() {
+		e.Updated = now
+	}
+	key := memoryKey(e)
+	if i, ok := m.index[key]; ok {
+		old := m.Entries[i]
+		if old.Origin == originHuman && e.Origin != originHuman {
+			return false
+		}
+		if old.Target == e.Target && old.Origin == e.Origin {
+			return false
+		}
+		e.Created = old.Created
+		m.Entries[i] = e
+	} else {
+		m.index[key] = len(m.Entries)
+		m.Entries = append(m.Entries, e)
+	}
+	m.dirty = true
+	return true
+}
+
+// Looks up an exact match for a segment translated without any options.
+func (m *translationMemory) exact(sourceLang, targetLang, source string) (memoryEntry, bool) {
+	return m.find(memoryEntry{SourceLang: sourceLang, TargetLang: targetLang, Source: source})
+}
+
+// Looks up the entry with the same scope and source as query.
+func (m *translationMemory) find(query memoryEntry) (memoryEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.index[memoryKey(query)]
+	if !ok {
+		return memoryEntry{}, false
+	}
+	return m.Entries[i], true
+}
+
+// Returns all entries for the same language pair whose similarity to source is at least threshold,
+// best first, whatever options they were translated with. Exact matches are included with a score of 1.
+func (m *translationMemory) fuzzy(sourceLang, targetLang, source string, threshold float64, limit int) []memoryMatch {
+	return m.matches(source, threshold, limit, func(e memoryEntry) bool {
+		return strings.EqualFold(e.SourceLang, sourceLang) && strings.EqualFold(e.TargetLang, targetLang)
+	})
+}
+
+// Like fuzzy, but only with entries in the same scope as query.
+func (m *translationMemory) similar(query memoryEntry, threshold float64, limit int) []memoryMatch {
+	scope := query.scope()
+	return m.matches(query.Source, threshold, limit, func(e memoryEntry) bool {
+		return e.scope() == scope
+	})
+}
+
+func (m *translationMemory) matches(source string, threshold float64, limit int, in func(memoryEntry) bool) []memoryMatch {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var matches []memoryMatch
+	for _, e := range m.Entries {
+		if !in(e) {
+			continue
+		}
+		if score := similarity(source, e.Source, threshold); score >= threshold {
+			matches = append(matches, memoryMatch{Entry: e, Score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		// human translations first, on a tie
+		return matches[i].Entry.Origin == originHuman && matches[j].Entry.Origin != originHuman
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// Similarity between two strings, from 0 (nothing in common) to 1 (identical),
+// based on the Levenshtein distance between their runes.
+// Returns 0 early if the result cannot possibly reach the threshold.
+func similarity(a, b string, threshold float64) float64 {
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) > len(rb) {
+		ra, rb = rb, ra
+	}
+	if len(rb) == 0 {
+		return 1
+	}
+	// the distance is at least the difference in length
+	if float64(len(ra))/float64(len(rb)) < threshold {
+		return 0
+	}
+	prev := make([]int, len(ra)+1)
+	curr := make([]int, len(ra)+1)
+	for i := range prev {
+		prev[i] = i
+	}
+	for j := 1; j <= len(rb); j++ {
+		curr[0] = j
+		for i := 1; i <= len(ra); i++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[i] = min(prev[i]+1, curr[i-1]+1, prev[i-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return 1 - float64(prev[len(ra)])/float64(len(rb))
+}
+
+// Options for translating through the memory.
+type memoryOptions struct {
+	SegmentBy	string	// "sentence", "paragraph" or "none".
+	Threshold	float64	// Minimum similarity for fuzzy matches.
+	UseFuzzy	bool	// Use fuzzy matches as translations, instead of just reporting them.
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitSegments(t *testing.T) {
+	inputs := []string{
+		"",
+		"One sentence without a full stop",
+		"  Hello there. How are you?  I'm fine!\n\nNew paragraph, version 3.14 of example.com.\n",
+		"「こんにちは。」元気ですか？はい。",
+		"He said \"Stop!\" and left.\tThe end…\r\n",
+	}
+	for _, by := range []string{segmentBySentence, segmentByParagraph, segmentByNone} {
+		for _, input := range inputs {
+			segments, err := splitSegments(input, by)
+			if err != nil {
+				t.Fatalf("Unexpected error splitting by %s: %s", by, err)
+			}
+			// joining with the identity translation must give back the input
+			identity := make(map[string]string)
+			for _, s := range segments {
+				identity[s.Text] = s.Text
+			}
+			if joined := joinSegments(segments, identity); joined != input {
+				t.Errorf("Splitting by %s is not reversible\nExpected: %q\nActual: %q", by, input, joined)
+			}
+		}
+	}
+
+	segments, _ := splitSegments("Hello there. How are you?  I'm fine!", segmentBySentence)
+	expected := []string{"Hello there.", "How are you?", "I'm fine!"}
+	if len(segments) != len(expected) {
+		t.Fatalf("Expected %d sentences, got %d: %#v", len(expected), len(segments), segments)
+	}
+	for i, s := range segments {
+		if s.Text != expected[i] {
+			t.Errorf("Sentence %d\nExpected: %q\nActual: %q", i, expected[i], s.Text)
+		}
+	}
+
+	segments, _ = splitSegments("Version 3.14 of example.com is out.", segmentBySentence)
+	if len(segments) != 1 {
+		t.Errorf("Full stops inside words should not split sentences: %#v", segments)
+	}
+
+	segments, _ = splitSegments("First line.\nStill first.\n\n  \nSecond.", segmentByParagraph)
+	if len(segments) != 2 || segments[0].Text != "First line.\nStill first." || segments[1].Text != "Second." {
+		t.Errorf("Unexpected paragraphs: %#v", segments)
+	}
+
+	if _, err := splitSegments("text", "words"); err == nil {
+		t.Errorf("Unknown segmentation modes should return an error")
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	if s := similarity("kitten", "kitten", 0.5); s != 1 {
+		t.Errorf("Identical strings should have similarity 1, got %f", s)
+	}
+	if s := similarity("kitten", "sitting", 0); s < 0.57 || s > 0.58 {
+		t.Errorf("Similarity between kitten and sitting should be 4/7, got %f", s)
+	}
+	if s := similarity("a", "a much longer string", 0.5); s != 0 {
+		t.Errorf("Strings of very different lengths should be discarded early, got %f", s)
+	}
+	if s := similarity("日本語です", "日本語でした", 0.5); s < 0.66 || s > 0.67 {
+		t.Errorf("Similarity should count runes, not bytes, got %f", s)
+	}
+}
+
+func TestTranslationMemory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory.json")
+	memory, err := loadMemory(path)
+	if err != nil {
+		t.Fatalf("A missing memory file should not be an error: %s", err)
+	}
+
+	memory.add(memoryEntry{SourceLang: "EN", TargetLang: "DE", Source: "Good morning.", Target: "Guten Morgen.", Origin: originHuman})
+	if memory.add(memoryEntry{SourceLang: "EN", TargetLang: "DE", Source: "Good morning.", Target: "Morgen.", Origin: originMachine}) {
+		t.Errorf("Machine translations should never overwrite human ones")
+	}
+	memory.add(memoryEntry{SourceLang: "EN", TargetLang: "DE", Source: "Good evening.", Target: "Guten Abend.", Origin: originMachine})
+	if err := memory.save(); err != nil {
+		t.Fatalf("Failed to save the memory: %s", err)
+	}
+
+	memory, err = loadMemory(path)
+	if err != nil {
+		t.Fatalf("Failed to reload the memory: %s", err)
+	}
+	if e, ok := memory.exact("en", "de", "Good morning."); !ok || e.Target != "Guten Morgen." {
+		t.Errorf("Expected an exact, case-insensitive match on the language pair, got %#v", e)
+	}
+	if _, ok := memory.exact("EN", "FR", "Good morning."); ok {
+		t.Errorf("Matches should be restricted to the same language pair")
+	}
+	matches := memory.fuzzy("EN", "DE", "Good mornings.", 0.8, 0)
+	if len(matches) != 1 || matches[0].Entry.Source != "Good morning." {
+		t.Errorf("Expected one fuzzy match, got %#v", matches)
+	}
+}
+
+func TestTMXRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	memory, _ := loadMemory(filepath.Join(dir, "memory.json"))
+	memory.add(memoryEntry{SourceLang: "EN", TargetLang: "DE", Source: "Fish & <chips>\nfor two.", Target: "Fisch & <Pommes>\nfür zwei.", Origin: originMachine})
+	memory.add(memoryEntry{SourceLang: "EN", TargetLang: "PT-BR", Formality: "less", Context: "A letter", Source: "Hello.", Target: "Olá.", Origin: originMachine})
+
+	var b bytes.Buffer
+	if err := memory.export(&b, memoryFormatTMX); err != nil {
+		t.Fatalf("Failed to export TMX: %s", err)
+	}
+	if !strings.Contains(b.String(), `xml:lang="PT-BR"`) {
+		t.Errorf("Variants should carry an xml:lang attribute:\n%s", b.String())
+	}
+	entries, err := parseTMX(&b)
+	if err != nil {
+		t.Fatalf("Failed to parse exported TMX: %s", err)
+	}
+	if len(entries) != 2 || entries[0].Source != memory.Entries[0].Source || entries[0].Target != memory.Entries[0].Target || entries[1].TargetLang != "PT-BR" || entries[1].Formality != "less" || entries[1].Context != "A letter" || entries[0].Origin != originMachine {
+		t.Errorf("TMX did not round-trip\nExpected: %#v\nActual: %#v", memory.Entries, entries)
+	}
+
+	// a correction made in a CAT tool, which uses its own language tags and inline markup
+	corrected := `<?xml version="1.0" encoding="UTF-8"?>
+<tmx version="1.4">
+  <header creationtool="SomeCAT" segtype="sentence" o-tmf="x" adminlang="en-US" srclang="en-US" datatype="plaintext"/>
+  <body>
+    <tu>
+      <prop type="x-origin">mt</prop>
+      <tuv xml:lang="de-DE"><seg>Hallo <ph>&lt;br/&gt;</ph>Welt.</seg></tuv>
+      <tuv xml:lang="en-US"><seg>Hello.</seg></tuv>
+    </tu>
+  </body>
+</tmx>`
+	path := filepath.Join(dir, "corrected.tmx")
+	if err := os.WriteFile(path, []byte(corrected), 0644); err != nil {
+		t.Fatal(err)
+	}
+	memory.add(memoryEntry{SourceLang: "EN", TargetLang: "DE", Source: "Hello.", Target: "Hallo.", Origin: originMachine})
+	if n, err := memory.importFile(path, ""); err != nil || n != 1 {
+		t.Fatalf("Expected one imported entry, got %d (error: %v)", n, err)
+	}
+	e, ok := memory.exact("EN", "DE", "Hello.")
+	if !ok || e.Target != "Hallo Welt." || e.Origin != originHuman {
+		t.Errorf("Corrections should replace machine translations and be marked as human, got %#v", e)
+	}
+}
@@ -0,0 +1,69 @@
+// This file keeps the whitespace of the input in its translation: DeepL may add or drop
+// whitespace around the text, turn CRLF into LF, or squeeze runs of blank lines, all of which
+// show up in diffs of translated files.
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Paragraph breaks: a line break followed by one or more blank lines.
+var blankLines = regexp.MustCompile(`[ \t]*\r?\n(?:[ \t]*\r?\n)+`)
+
+// The whitespace of a text, as opposed to its words.
+type textShape struct {
+	leading		string		// Whitespace before the text.
+	trailing	string		// Whitespace after the text, including any final newline.
+	crlf		bool		// Does every line end with CRLF?
+	breaks		[]string	// Paragraph breaks, in order, exactly as they are.
+}
+
+// Records the whitespace of text.
+func shapeOf(text string) textShape {
+	body := strings.TrimLeftFunc(text, unicode.IsSpace)
+	leading := text[:len(text)-len(body)]
+	body = strings.TrimRightFunc(body, unicode.IsSpace)
+	lines := strings.Count(body, "\n")
+	return textShape{
+		leading:	leading,
+		trailing:	text[len(leading)+len(body):],
+		crlf:		lines > 0 && strings.Count(body, "\r\n") == lines,
+		breaks:		blankLines.FindAllString(body, -1),
+	}
+}
+
+// Gives translation the same shape. Paragraph breaks are only restored if the translation
+// has as many as the original, since otherwise there is no telling which is which.
+func (s textShape) apply(translation string) string {
+	body := strings.ReplaceAll(strings.TrimSpace(translation), "\r\n", "\n")
+	paragraphs := blankLines.Split(body, -1)
+	breaks := blankLines.FindAllString(body, -1)
+	restored := len(breaks) == len(s.breaks)
+	if restored {
+		breaks = s.breaks
+	}
+	var b strings.Builder
+	b.WriteString(s.leading)
+	for i, paragraph := range paragraphs {
+		if s.crlf {
+			paragraph = strings.ReplaceAll(paragraph, "\n", "\r\n")
+		}
+		b.WriteString(paragraph)
+		if i < len(breaks) {
+			if s.crlf && !restored {
+				b.WriteString(strings.ReplaceAll(breaks[i], "\n", "\r\n"))
+			} else {
+				b.WriteString(breaks[i])
+			}
+		}
+	}
+	b.WriteString(s.trailing)
+	return b.String()
+}
+
+// Returns translation with the whitespace and line endings of source.
+func preserveWhitespace(source, translation string) string {
+	return shapeOf(source).apply(translation)
+}
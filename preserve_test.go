@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+)
+
+func TestPreserveWhitespace(t *testing.T) {
+	for _, c := range []struct {
+		source, translation, expected string
+	}{
+		{"  Hello.\n", "Hallo.", "  Hallo.\n"},
+		{"Hello.", " Hallo.\n\n", "Hallo."},
+		{"One.\r\nTwo.\r\n", "Eins.\nZwei.", "Eins.\r\nZwei.\r\n"},
+		{"One.\n\n\n\nTwo.\n \nThree.", "Eins.\n\nZwei.\n\nDrei.", "Eins.\n\n\n\nZwei.\n \nDrei."},
+		{"One.\r\n\r\n\r\nTwo.", "Eins.\r\n\r\nZwei.", "Eins.\r\n\r\n\r\nZwei."},
+		// without as many paragraphs, the translation keeps its own breaks, in the right style.
+		{"One.\r\n\r\n\r\nTwo.", "Eins. Zwei.\n\nDrei.\n\nVier.", "Eins. Zwei.\r\n\r\nDrei.\r\n\r\nVier."},
+		// mixed line endings are left alone.
+		{"One.\r\nTwo.\nThree.", "Eins.\nZwei.\nDrei.", "Eins.\nZwei.\nDrei."},
+	} {
+		if actual := preserveWhitespace(c.source, c.translation); actual != c.expected {
+			t.Errorf("Expected %q for %q ⇒ %q\nActual: %q", c.expected, c.source, c.translation, actual)
+		}
+	}
+}
+
+func TestTranslatorPreservesWhitespace(t *testing.T) {
+	// like DeepL at its worst: LF only, blank lines squeezed, and a stray newline at the end.
+	squeeze := regexp.MustCompile(`\n{2,}`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		var response deepl.DeepLResponse
+		for _, text := range r.PostForm["text"] {
+			text = squeeze.ReplaceAllString(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n")
+			response.Translations = append(response.Translations, deepl.Translated{DetectedSourceLanguage: "EN", Text: strings.ToUpper(text) + "\n"})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	input := "\r\n  One.\r\nTwo.\r\n\r\n\r\nThree.\r\n\r\n"
+	for preserve, expected := range map[bool]string{
+		true:	"\r\n  ONE.\r\nTWO.\r\n\r\n\r\nTHREE.\r\n\r\n",
+		false:	"\r\n  ONE.\nTWO.\n\nTHREE.\n\r\n\r\n",
+	} {
+		tr := translator{client: &deepl.DeepLClient{Endpoint: server.URL, TargetLang: "DE"}, preserve: preserve}
+		plan, err := tr.plan([]translationInput{{Name: "a", Text: input}})
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		outputs, err := tr.execute(plan)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if outputs[0] != expected {
+			t.Errorf("Expected output with preserve=%v: %q\nActual: %q", preserve, expected, outputs[0])
+		}
+	}
+}
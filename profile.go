@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Applies the named profile on top of setting. Profiles are defined under "profiles"
+// in any of the settings files, each one as a set of settings, e.g.
+//
+//	"profiles": {
+//		"work": {"token_env": "DEEPL_WORK_TOKEN", "pro": true, "target_lang": "DE"},
+//		"marketing": {"formality": "prefer_less", "glossary": "campaign"}
+//	}
+//
+// A profile defined in the project file replaces one with the same name in the user file.
+func applyProfile(setting *Setting, origins settingOrigins, name string) error {
+	raw, ok := setting.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q; the settings files define: %s", name, strings.Join(profileNames(*setting), ", "))
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return fmt.Errorf("%s (occurred while reading profile %q)", err, name)
+	}
+	for key := range keys {
+		if key == "profile" || key == "profiles" {
+			return fmt.Errorf("profile %q cannot set %q", name, key)
+		}
+		if _, ok := origins[key]; !ok {
+			return fmt.Errorf("unknown setting %q in profile %q", key, name)
+		}
+	}
+	if err := json.Unmarshal(raw, setting); err != nil {
+		return fmt.Errorf("%s (occurred while reading profile %q)", err, name)
+	}
+	for key := range keys {
+		origins[key] = "profile " + name
+	}
+	return nil
+}
+
+// Returns the names of all the profiles defined in the settings, sorted; "(none)" if there are none.
+func profileNames(setting Setting) []string {
+	if len(setting.Profiles) == 0 {
+		return []string{"(none)"}
+	}
+	names := make([]string, 0, len(setting.Profiles))
+	for name := range setting.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
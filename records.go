@@ -0,0 +1,93 @@
+// This file implements the record mode of the translate command, where the input is a series of
+// independent texts separated by a delimiter (e.g. NUL, as with `find -print0`).
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Returns the record delimiter given with -0 or --delimiter; empty if there is none. Backslash
+// escapes, such as \n, \t or \x00, are understood.
+func recordDelimiter(c *cli.Context) (string, error) {
+	switch {
+		case c.Bool("null") && c.IsSet("delimiter"):
+			return "", fmt.Errorf("choose either -0 or --delimiter, not both")
+		case c.Bool("null"):
+			return "\x00", nil
+		case !c.IsSet("delimiter"):
+			return "", nil
+	}
+	delimiter := c.String("delimiter")
+	if unquoted, err := strconv.Unquote(`"` + strings.ReplaceAll(delimiter, `"`, `\"`) + `"`); err == nil {
+		delimiter = unquoted
+	}
+	if delimiter == "" {
+		return "", fmt.Errorf("the delimiter cannot be empty")
+	}
+	return delimiter, nil
+}
+
+// Splits each input into its records, each of which becomes an input of its own (with the same
+// name); also returns how many records each input has.
+func splitRecords(inputs []translationInput, delimiter string) ([]translationInput, []int) {
+	var records []translationInput
+	counts := make([]int, len(inputs))
+	for i, input := range inputs {
+		texts := strings.Split(input.Text, delimiter)
+		for _, text := range texts {
+			records = append(records, translationInput{Name: input.Name, Text: text})
+		}
+		counts[i] = len(texts)
+	}
+	return records, counts
+}
+
+// Puts the translations of the records back together, with the same delimiter, one per input.
+func joinRecords(outputs []string, counts []int, delimiter string) []string {
+	joined := make([]string, len(counts))
+	for i, count := range counts {
+		joined[i] = strings.Join(outputs[:count], delimiter)
+		outputs = outputs[count:]
+	}
+	return joined
+}
+
+// Puts the records of each input back together in a plan, which is only good for estimating it.
+func joinRecordPlan(p *translationPlan, inputs []translationInput, counts []int) *translationPlan {
+	joined := &translationPlan{inputs: inputs}
+	next := 0
+	for _, count := range counts {
+		var segments []segment
+		billable, characters := 0, 0
+		for i := next; i < next+count; i++ {
+			segments = append(segments, p.segments[i]...)
+			billable += p.billable[i]
+			characters += p.characters[i]
+		}
+		next += count
+		joined.segments = append(joined.segments, segments)
+		joined.billable = append(joined.billable, billable)
+		joined.characters = append(joined.characters, characters)
+	}
+	return joined
+}
+
+// Returns a bufio.SplitFunc for records ending with delimiter, which is kept at the end of each
+// record; the last record may lack it.
+func scanRecords(delimiter string) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.Index(data, []byte(delimiter)); i >= 0 {
+			return i + len(delimiter), data[:i+len(delimiter)], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
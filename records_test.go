@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+)
+
+func TestRecords(t *testing.T) {
+	inputs := []translationInput{{Name: "a", Text: "One.\x00Two.\x00"}, {Name: "b", Text: "One."}}
+	records, counts := splitRecords(inputs, "\x00")
+	if len(records) != 4 || records[1].Text != "Two." || records[2].Text != "" || records[3].Name != "b" {
+		t.Errorf("Unexpected records: %#v", records)
+	}
+	if counts[0] != 3 || counts[1] != 1 {
+		t.Errorf("Unexpected counts: %v", counts)
+	}
+
+	tr := translator{client: &deepl.DeepLClient{TargetLang: "DE"}}
+	plan, err := tr.plan(records)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	estimate := joinRecordPlan(plan, inputs, counts)
+	if len(estimate.inputs) != 2 || estimate.billable[0] != 8 || estimate.billable[1] != 0 || estimate.characters[1] != 4 {
+		t.Errorf("Unexpected estimate: %#v", estimate)
+	}
+
+	outputs := joinRecords([]string{"EINS.", "ZWEI.", "", "EINS."}, counts, "\x00")
+	if len(outputs) != 2 || outputs[0] != "EINS.\x00ZWEI.\x00" || outputs[1] != "EINS." {
+		t.Errorf("Unexpected outputs: %q", outputs)
+	}
+}
+
+func TestScanRecords(t *testing.T) {
+	scanner := bufio.NewScanner(strings.NewReader("a--b----c"))
+	scanner.Split(scanRecords("--"))
+	var tokens []string
+	for scanner.Scan() {
+		tokens = append(tokens, scanner.Text())
+	}
+	if expected := "a--|b--|--|c"; strings.Join(tokens, "|") != expected {
+		t.Errorf("Expected records: %s\nActual: %s", expected, strings.Join(tokens, "|"))
+	}
+}
@@ -0,0 +1,357 @@
+// This file implements the interactive mode of the translate command.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+	"github.com/lmorg/readline"
+)
+
+// Name of the file, inside configDir(), with the lines typed in interactive mode.
+const historyName = "history"
+
+// Maximum number of lines kept in the history.
+const historyMaxLines = 1000
+
+// Returned by a line reader when the user presses Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+// Reads one line with the given prompt; returns io.EOF at the end of the input (Ctrl-D), along with
+// whatever was typed on the line before it, and errInterrupted if the user gives up on what they
+// were typing (Ctrl-C).
+type lineReader func(prompt string) (string, error)
+
+// Readline history kept in a file, so that it survives from one session to the next.
+type fileHistory struct {
+	path	string
+	lines	[]string
+}
+
+// Loads the history from path; a missing file is just an empty history.
+func loadHistory(path string) (*fileHistory, error) {
+	h := &fileHistory{path: path}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		h.lines = append(h.lines, scanner.Text())
+	}
+	if len(h.lines) > historyMaxLines {
+		h.lines = h.lines[len(h.lines)-historyMaxLines:]
+	}
+	return h, scanner.Err()
+}
+
+// Appends a line to the history (and its file); blank lines and repetitions are left out.
+func (h *fileHistory) Write(line string) (int, error) {
+	if strings.TrimSpace(line) == "" || (len(h.lines) > 0 && h.lines[len(h.lines)-1] == line) {
+		return len(h.lines), nil
+	}
+	h.lines = append(h.lines, line)
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return len(h.lines), err
+	}
+	// the history may well contain private text, like the translation memory.
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return len(h.lines), err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, line)
+	return len(h.lines), err
+}
+
+func (h *fileHistory) GetLine(i int) (string, error) {
+	if i < 0 || i >= len(h.lines) {
+		return "", fmt.Errorf("no history line %d", i)
+	}
+	return h.lines[i], nil
+}
+
+func (h *fileHistory) Len() int {
+	return len(h.lines)
+}
+
+func (h *fileHistory) Dump() interface{} {
+	return h.lines
+}
+
+// Returns the path of the history file: the one set in the settings, or the default one.
+func historyPath(setting Setting) (string, error) {
+	if setting.HistoryPath != "" {
+		return setting.HistoryPath, nil
+	}
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, historyName), nil
+}
+
+// Key that ends the input.
+const ctrlD = "\x04"
+
+// Reads lines from the terminal, with the given history.
+func terminalLines(history readline.History) lineReader {
+	rl := readline.NewInstance()
+	rl.History = history
+	// readline drops the line on Ctrl-D, so it is kept here before that.
+	var typed string
+	rl.AddEvent(ctrlD, func(_ string, line []rune, pos int) *readline.EventReturn {
+		typed = string(line)
+		return &readline.EventReturn{ForwardKey: true, NewLine: line, NewPos: pos}
+	})
+	return func(prompt string) (string, error) {
+		typed = ""
+		rl.SetPrompt(prompt)
+		line, err := rl.Readline()
+		switch {
+			case errors.Is(err, readline.CtrlC):
+				return "", errInterrupted
+			case errors.Is(err, readline.EOF):
+				return typed, io.EOF
+		}
+		return line, err
+	}
+}
+
+// State shared by the interactive modes: the settings can be changed between translations,
+// which all go through the same translator (and client).
+type session struct {
+	setting		*Setting
+	t			*translator
+	langs		*languageLists		// For checking language codes; nil if they are not known.
+	glossaries	*deepl.DeepLClient	// For resolving glossary names; nil without a token.
+	info		io.Writer			// Warnings.
+}
+
+func newSession(setting *Setting, ts *translationSetup, info io.Writer) session {
+	return session{setting: setting, t: ts.t, langs: ts.langs, glossaries: ts.glossaries, info: info}
+}
+
+// The interactive mode: text is read until a blank line (or Ctrl-D), and translated with the
+// same client every time; lines starting with ":" are commands that change the settings.
+type repl struct {
+	session
+	read	lineReader
+	out		io.Writer	// Translations; everything else goes to info.
+}
+
+const replHelp = `Type or paste the text to translate, and submit it with an empty line (or Ctrl-D).
+Commands:
+  :source LANG      translate from LANG (":source" alone detects it)
+  :target LANG      translate into LANG
+  :swap             swap the source and target languages
+  :formality VALUE  one of default, more, less, prefer_more or prefer_less
+  :glossary NAME    use the glossary with that name or ID (":glossary" alone stops using it)
+  :help             show this help
+  :quit             leave (so does Ctrl-D on an empty prompt)
+`
+
+// Reads and translates until the end of the input.
+func (r *repl) run() error {
+	fmt.Fprint(r.info, "Interactive mode; type :help for help.\n")
+	for {
+		text, err := r.readText()
+		if text != "" {
+			command, args, _ := strings.Cut(strings.TrimSpace(text), " ")
+			switch {
+				case command == ":quit" || command == ":q":
+					return nil
+				case command == ":help" || command == ":h":
+					fmt.Fprint(r.info, replHelp)
+				case strings.HasPrefix(command, ":"):
+					if err := r.command(command, strings.TrimSpace(args)); err != nil {
+						fmt.Fprintf(r.info, "%s\n", err)
+					}
+				default:
+					if err := r.translate(text); err != nil {
+						fmt.Fprintf(r.info, "%s\n", err)
+					}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Returns the prompt, which shows the language pair.
+func (r *repl) prompt() string {
+	source := r.t.client.SourceLang
+	if source == "" {
+		source = "auto"
+	}
+	return fmt.Sprintf("%s ⇒ %s> ", source, r.t.client.TargetLang)
+}
+
+// Reads lines up to a blank one, or up to Ctrl-D; a command is a text by itself.
+// Ctrl-C throws away what was typed so far. Returns io.EOF only for Ctrl-D on an empty prompt.
+func (r *repl) readText() (string, error) {
+	var lines []string
+	for {
+		prompt := r.prompt()
+		if len(lines) > 0 {
+			prompt = strings.Repeat(" ", len([]rune(prompt))-2) + "… "
+		}
+		line, err := r.read(prompt)
+		if errors.Is(err, errInterrupted) {
+			lines = nil
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			if strings.TrimSpace(line) != "" {
+				lines = append(lines, line)
+			}
+			if len(lines) == 0 {
+				return "", io.EOF
+			}
+			// submits the text, like a blank line.
+			return strings.Join(lines, "\n"), nil
+		}
+		if err != nil {
+			return strings.Join(lines, "\n"), err
+		}
+		if len(lines) == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			return line, nil
+		}
+		if strings.TrimSpace(line) == "" {
+			if len(lines) == 0 {
+				continue
+			}
+			return strings.Join(lines, "\n"), nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+// Translates one text, showing the detected source language.
+func (r *repl) translate(text string) error {
+	translation, source, _, err := r.session.translate(text)
+	if err != nil {
+		return err
+	}
+	if source != "" {
+		fmt.Fprintf(r.info, "[%s ⇒ %s]\n", source, r.t.client.TargetLang)
+	}
+	fmt.Fprintln(r.out, strings.TrimRight(translation, "\n"))
+	return nil
+}
+
+// Translates one text, within the budgets; returns the translation, the source language (as
+// detected, if it was not set) and the billed characters.
+func (s *session) translate(text string) (string, string, int, error) {
+	plan, err := s.t.plan([]translationInput{{Name: stdinName, Text: text}})
+	if err != nil {
+		return "", "", 0, err
+	}
+	if s.t.guard != nil {
+		if err := s.t.guard.checkBudgets(plan.totalBillable()); err != nil {
+			return "", "", 0, err
+		}
+	}
+	outputs, err := s.t.execute(plan)
+	if err != nil {
+		return "", "", 0, err
+	}
+	source := s.t.detected
+	if source == "" {
+		source = s.t.client.SourceLang
+	}
+	return outputs[0], source, plan.totalBillable(), nil
+}
+
+// Runs one of the commands that change the settings.
+func (s *session) command(command string, arg string) error {
+	switch command {
+		case ":source":
+			return s.setLanguages(arg, s.setting.TargetLang)
+		case ":target":
+			if arg == "" {
+				return fmt.Errorf(":target needs a language")
+			}
+			return s.setLanguages(s.setting.SourceLang, arg)
+		case ":swap":
+			source := s.setting.SourceLang
+			if source == "" {
+				// the language last detected, if any.
+				source = s.t.detected
+			}
+			if source == "" {
+				return fmt.Errorf("nothing to swap with yet; set the source language with :source")
+			}
+			return s.setLanguages(s.setting.TargetLang, source)
+		case ":formality":
+			if err := validateSetting("formality", arg); err != nil {
+				return err
+			}
+			s.setting.Formality = arg
+			s.t.client.Formality = arg
+			return nil
+		case ":glossary":
+			s.setting.Glossary = arg
+			return s.applyGlossary()
+		default:
+			return fmt.Errorf("unknown command %s; type :help for help", command)
+	}
+}
+
+// Changes the language pair, checking it first; the glossary must then match the new pair.
+func (s *session) setLanguages(source, target string) error {
+	changed := *s.setting
+	changed.SourceLang, changed.TargetLang = source, target
+	if err := changed.resolveLanguages(s.langs, s.info); err != nil {
+		return err
+	}
+	if s.langs == nil {
+		// nothing else will catch it.
+		changed.SourceLang, changed.TargetLang = strings.ToUpper(changed.SourceLang), strings.ToUpper(changed.TargetLang)
+		if changed.SourceLang == changed.TargetLang {
+			return fmt.Errorf("cannot have identical source lang(%s) and target lang(%s)", changed.SourceLang, changed.TargetLang)
+		}
+	}
+	s.setting.SourceLang, s.setting.TargetLang = changed.SourceLang, changed.TargetLang
+	s.t.client.SourceLang, s.t.client.TargetLang = changed.SourceLang, changed.TargetLang
+	if s.setting.Glossary != "" {
+		if err := s.applyGlossary(); err != nil {
+			s.setting.Glossary = ""
+			return fmt.Errorf("%s; no longer using it", err)
+		}
+	}
+	return nil
+}
+
+// Resolves the glossary of the settings for the current language pair.
+func (s *session) applyGlossary() error {
+	s.t.client.GlossaryID = ""
+	if s.setting.Glossary == "" {
+		return nil
+	}
+	if s.glossaries == nil {
+		return fmt.Errorf("glossaries cannot be used without a DeepL token")
+	}
+	s.glossaries.SourceLang, s.glossaries.TargetLang = s.t.client.SourceLang, s.t.client.TargetLang
+	id, err := resolveGlossary(s.glossaries, s.setting.Glossary)
+	if err != nil {
+		return err
+	}
+	s.t.client.GlossaryID = id
+	return nil
+}
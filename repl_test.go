@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+)
+
+// Returns a line reader going through lines, then reporting the end of the input;
+// "^C" stands for Ctrl-C, and a line ending with "^D" for Ctrl-D after typing the rest of it.
+func scriptedLines(lines ...string) lineReader {
+	return func(prompt string) (string, error) {
+		if len(lines) == 0 {
+			return "", io.EOF
+		}
+		line := lines[0]
+		lines = lines[1:]
+		if line == "^C" {
+			return "", errInterrupted
+		}
+		if typed, ok := strings.CutSuffix(line, "^D"); ok {
+			return typed, io.EOF
+		}
+		return line, nil
+	}
+}
+
+func TestREPL(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		requests = append(requests, r.PostForm.Get("source_lang")+">"+r.PostForm.Get("target_lang")+":"+r.PostForm.Get("formality")+":"+strings.Join(r.PostForm["text"], "|"))
+		var response deepl.DeepLResponse
+		for _, text := range r.PostForm["text"] {
+			response.Translations = append(response.Translations, deepl.Translated{DetectedSourceLanguage: "EN", Text: strings.ToUpper(text)})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	setting := defaultSettings()
+	setting.SourceLang = ""
+	client := deepl.DeepLClient{Endpoint: server.URL, TargetLang: "JA"}
+	tr := translator{client: &client}
+	var out, info bytes.Buffer
+	r := repl{
+		session:	session{setting: &setting, t: &tr, langs: testLanguages, info: &info},
+		read:		scriptedLines(
+			"first line", "second line", "",
+			"", "thrown", "^C",
+			":target german", ":formality more", "hello", "",
+			":swap",
+			":formality extreme",
+			":glossary terms",
+			"last words",
+		),
+		out:		&out,
+	}
+	if err := r.run(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := ">JA::first line\nsecond line|>DE:more:hello|DE>EN-US:more:last words"
+	if strings.Join(requests, "|") != expected {
+		t.Errorf("Expected requests: %s\nActual: %s", expected, strings.Join(requests, "|"))
+	}
+	if expected := "FIRST LINE\nSECOND LINE\nHELLO\nLAST WORDS\n"; out.String() != expected {
+		t.Errorf("Expected output: %q\nActual: %q", expected, out.String())
+	}
+	for _, expected := range []string{"[EN ⇒ JA]", "[EN ⇒ DE]", "formality must be one of", "glossaries cannot be used", "the target language EN is deprecated"} {
+		if !strings.Contains(info.String(), expected) {
+			t.Errorf("Expected %q in:\n%s", expected, info.String())
+		}
+	}
+}
+
+func TestREPLCtrlD(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		requests = append(requests, strings.Join(r.PostForm["text"], "|"))
+		var response deepl.DeepLResponse
+		for _, text := range r.PostForm["text"] {
+			response.Translations = append(response.Translations, deepl.Translated{DetectedSourceLanguage: "EN", Text: strings.ToUpper(text)})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	setting := defaultSettings()
+	tr := translator{client: &deepl.DeepLClient{Endpoint: server.URL, TargetLang: "JA"}}
+	var out, info bytes.Buffer
+	r := repl{
+		session:	session{setting: &setting, t: &tr, langs: testLanguages, info: &info},
+		// Ctrl-D submits what was typed, including the current line, and only leaves on an empty prompt.
+		read:		scriptedLines("first line", "second line^D", "another^D", "", "again", "^D", "^D", "never sent"),
+		out:		&out,
+	}
+	if err := r.run(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := "first line\nsecond line|another|again"; strings.Join(requests, "|") != expected {
+		t.Errorf("Expected requests: %q\nActual: %q", expected, strings.Join(requests, "|"))
+	}
+	if expected := "FIRST LINE\nSECOND LINE\nANOTHER\nAGAIN\n"; out.String() != expected {
+		t.Errorf("Expected output: %q\nActual: %q", expected, out.String())
+	}
+}
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	h, err := loadHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"one", "", "two", "two", ":swap"} {
+		if _, err := h.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err = loadHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := h.Dump().([]string); strings.Join(lines, ",") != "one,two,:swap" {
+		t.Errorf("Unexpected history: %q", lines)
+	}
+	if line, err := h.GetLine(1); err != nil || line != "two" {
+		t.Errorf("Expected the second line, got %q (%v)", line, err)
+	}
+}
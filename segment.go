@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// Ways of splitting the input into segments for the translation memory.
+const (
+	segmentBySentence  = "sentence"
+	segmentByParagraph = "paragraph"
+	segmentByNone      = "none" // The whole input is a single segment.
+)
+
+// A segment is a piece of text that gets translated (and remembered) on its own,
+// followed by the whitespace that separated it from the next one.
+// The whitespace is never sent to DeepL; it is simply copied to the output.
+type segment struct {
+	Text  string
+	Space string
+}
+
+// Splits text into segments. Leading whitespace, if any, becomes a segment without text,
+// so that joining all segments back together always returns the original input.
+func splitSegments(text string, by string) ([]segment, error) {
+	lead := len(text) - len(strings.TrimLeftFunc(text, unicode.IsSpace))
+	var segments []segment
+	if lead > 0 {
+		segments = append(segments, segment{Space: text[:lead]})
+	}
+	text = text[lead:]
+
+	switch by {
+		case segmentBySentence:
+			return append(segments, splitSentences(text)...), nil
+		case segmentByParagraph:
+			return append(segments, splitParagraphs(text)...), nil
+		case segmentByNone, "":
+			if text == "" {
+				return segments, nil
+			}
+			body := strings.TrimRightFunc(text, unicode.IsSpace)
+			return append(segments, segment{Text: body, Space: text[len(body):]}), nil
+		default:
+			return nil, fmt.Errorf("segmentation must be either `sentence`, `paragraph` or `none` (got: %s)", by)
+	}
+}
+
+// Joins segments back, replacing each text by its translation.
+func joinSegments(segments []segment, translations map[string]string) string {
+	var b strings.Builder
+	for _, s := range segments {
+		if s.Text != "" {
+			b.WriteString(translations[s.Text])
+		}
+		b.WriteString(s.Space)
+	}
+	return b.String()
+}
+
+// Sentence terminators; the CJK ones do not need to be followed by whitespace.
+const (
+	sentenceEnds    = ".!?…"
+	cjkSentenceEnds = "。！？"
+	closingMarks    = "\"')]}»”’」』"
+)
+
+// Splits text (without leading whitespace) on sentence boundaries.
+// This is a heuristic: abbreviations such as "e.g. this" will be split as well,
+// which is harmless for the translation memory, since the same split happens every time.
+func splitSentences(text string) []segment {
+	var segments []segment
+	runes := []rune(text)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		cjk := strings.ContainsRune(cjkSentenceEnds, r)
+		if !cjk && !strings.ContainsRune(sentenceEnds, r) {
+			continue
+		}
+		// include repeated terminators and closing quotes/brackets in the sentence
+		end := i + 1
+		for end < len(runes) && (strings.ContainsRune(sentenceEnds+cjkSentenceEnds, runes[end]) || strings.ContainsRune(closingMarks, runes[end])) {
+			end++
+		}
+		space := end
+		for space < len(runes) && unicode.IsSpace(runes[space]) {
+			space++
+		}
+		if space == end && !cjk && end < len(runes) {
+			// no whitespace after a Latin terminator, e.g. "3.14" or "example.com"
+			continue
+		}
+		segments = append(segments, segment{Text: string(runes[start:end]), Space: string(runes[end:space])})
+		start = space
+		i = space - 1
+	}
+	if start < len(runes) {
+		body := strings.TrimRightFunc(string(runes[start:]), unicode.IsSpace)
+		segments = append(segments, segment{Text: body, Space: string(runes[start:])[len(body):]})
+	}
+	return segments
+}
+
+// Splits text (without leading whitespace) on blank lines.
+func splitParagraphs(text string) []segment {
+	var segments []segment
+	for text != "" {
+		idx := paragraphBreak(text)
+		if idx < 0 {
+			body := strings.TrimRightFunc(text, unicode.IsSpace)
+			segments = append(segments, segment{Text: body, Space: text[len(body):]})
+			break
+		}
+		body := strings.TrimRightFunc(text[:idx], unicode.IsSpace)
+		rest := strings.TrimLeftFunc(text[idx:], unicode.IsSpace)
+		segments = append(segments, segment{Text: body, Space: text[len(body) : len(text)-len(rest)]})
+		text = rest
+	}
+	return segments
+}
+
+// Returns the index of the first line break that is followed by a blank line, or -1.
+func paragraphBreak(text string) int {
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\n' {
+			continue
+		}
+		for j := i + 1; j < len(text); j++ {
+			switch text[j] {
+				case ' ', '\t', '\r':
+					continue
+				case '\n':
+					return i
+			}
+			break
+		}
+	}
+	return -1
+}
+
+// Splits text into chunks whose URL-encoded size is at most limit bytes, so that each fits into
+// a request: on paragraph boundaries if possible, then on sentence boundaries, and, as a last
+// resort, anywhere. Text that is small enough becomes a single chunk.
+func chunkText(text string, limit int) []segment {
+	segments, _ := splitSegments(text, segmentByNone)
+	if len(segments) == 0 || encodedSize(segments[len(segments)-1].Text) <= limit {
+		return segments
+	}
+
+	// break everything down into pieces that fit...
+	var pieces []segment
+	paragraphs, _ := splitSegments(text, segmentByParagraph)
+	for _, p := range paragraphs {
+		if encodedSize(p.Text) <= limit {
+			pieces = append(pieces, p)
+			continue
+		}
+		sentences := splitSentences(p.Text)
+		sentences[len(sentences)-1].Space += p.Space
+		for _, s := range sentences {
+			if encodedSize(s.Text) <= limit {
+				pieces = append(pieces, s)
+				continue
+			}
+			pieces = append(pieces, cutText(s, limit)...)
+		}
+	}
+
+	// ... and then merge them back together, as long as they still fit.
+	var chunks []segment
+	for _, p := range pieces {
+		if n := len(chunks); n > 0 && chunks[n-1].Text != "" {
+			merged := chunks[n-1].Text + chunks[n-1].Space + p.Text
+			if p.Text != "" && encodedSize(merged) <= limit {
+				chunks[n-1] = segment{Text: merged, Space: p.Space}
+				continue
+			}
+		}
+		chunks = append(chunks, p)
+	}
+	return chunks
+}
+
+// Cuts a segment into pieces of at most limit bytes (URL-encoded), without splitting runes.
+func cutText(s segment, limit int) []segment {
+	var pieces []segment
+	var b strings.Builder
+	size := 0
+	for _, r := range s.Text {
+		rs := encodedSize(string(r))
+		if size+rs > limit && b.Len() > 0 {
+			pieces = append(pieces, segment{Text: b.String()})
+			b.Reset()
+			size = 0
+		}
+		b.WriteRune(r)
+		size += rs
+	}
+	pieces = append(pieces, segment{Text: b.String(), Space: s.Space})
+	return pieces
+}
+
+// Size of text once encoded in the request body.
+func encodedSize(text string) int {
+	return len(url.QueryEscape(text))
+}
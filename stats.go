@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Dimensions by which the `stats` command can summarise the ledger.
+var statsDimensions = map[string]func(r ledgerRecord) string{
+	"day": func(r ledgerRecord) string {
+		return r.Time.Local().Format(time.DateOnly)
+	},
+	"month": func(r ledgerRecord) string {
+		return r.Time.Local().Format("2006-01")
+	},
+	"project": func(r ledgerRecord) string {
+		return orNone(r.Project)
+	},
+	"pair": func(r ledgerRecord) string {
+		return orNone(r.SourceLang) + " ⇒ " + r.TargetLang
+	},
+	"user": func(r ledgerRecord) string {
+		return orNone(r.User)
+	},
+	"file": func(r ledgerRecord) string {
+		return orNone(r.File)
+	},
+}
+
+// Default dimensions shown by `stats`.
+var defaultStatsDimensions = []string{"day", "project", "pair", "user"}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
+
+// Spending for one value of a dimension (e.g. one day, or one project).
+type statsRow struct {
+	Key			string	`json:"key"`
+	Requests	int		`json:"requests"`	// Distinct requests; older records without an ID count one each.
+	Characters	int		`json:"characters"`
+}
+
+// Filters applied to the ledger before summarising it.
+type statsFilter struct {
+	Since	time.Time	// Zero means from the beginning.
+	Until	time.Time	// Zero means up to now; exclusive.
+	Project	string
+	User	string
+}
+
+func (f statsFilter) match(r ledgerRecord) bool {
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !r.Time.Before(f.Until) {
+		return false
+	}
+	if f.Project != "" && r.Project != f.Project {
+		return false
+	}
+	if f.User != "" && r.User != f.User {
+		return false
+	}
+	return true
+}
+
+// Summarises the ledger by each of the given dimensions.
+func summariseLedger(l *spendLedger, dimensions []string, filter statsFilter) (map[string][]statsRow, error) {
+	for _, d := range dimensions {
+		if _, ok := statsDimensions[d]; !ok {
+			return nil, fmt.Errorf("cannot summarise by %q; use one of: %s", d, strings.Join(statsDimensionNames(), ", "))
+		}
+	}
+	totals := make(map[string]map[string]*statsRow, len(dimensions))
+	for _, d := range dimensions {
+		totals[d] = make(map[string]*statsRow)
+	}
+	// a request for several files leaves a record for each one, which count as one request.
+	seen := make(map[string]bool)
+	err := l.each(func(r ledgerRecord) {
+		if !filter.match(r) {
+			return
+		}
+		for _, d := range dimensions {
+			key := statsDimensions[d](r)
+			row, ok := totals[d][key]
+			if !ok {
+				row = &statsRow{Key: key}
+				totals[d][key] = row
+			}
+			if id := d + "\x00" + key + "\x00" + r.Request; r.Request == "" || !seen[id] {
+				seen[id] = true
+				row.Requests++
+			}
+			row.Characters += r.Characters
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	summary := make(map[string][]statsRow, len(dimensions))
+	for _, d := range dimensions {
+		rows := make([]statsRow, 0, len(totals[d]))
+		for _, row := range totals[d] {
+			rows = append(rows, *row)
+		}
+		sort.Slice(rows, func(i, j int) bool {
+			// dates are listed chronologically, everything else by spending.
+			if d != "day" && d != "month" && rows[i].Characters != rows[j].Characters {
+				return rows[i].Characters > rows[j].Characters
+			}
+			return rows[i].Key < rows[j].Key
+		})
+		summary[d] = rows
+	}
+	return summary, nil
+}
+
+func statsDimensionNames() []string {
+	names := make([]string, 0, len(statsDimensions))
+	for name := range statsDimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Writes the summary, one table per dimension, or as a JSON object keyed by dimension.
+func printStats(w io.Writer, summary map[string][]statsRow, dimensions []string, output string) error {
+	if output == outputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(summary)
+	}
+	for i, d := range dimensions {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "%s\tRequests\tCharacters\n", strings.ToUpper(d[:1])+d[1:])
+		total := statsRow{Key: "Total"}
+		for _, row := range summary[d] {
+			fmt.Fprintf(tw, "%s\t%d\t%d\n", row.Key, row.Requests, row.Characters)
+			total.Requests += row.Requests
+			total.Characters += row.Characters
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", total.Key, total.Requests, total.Characters)
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
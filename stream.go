@@ -0,0 +1,155 @@
+// This file implements the streaming mode of the translate command, for never-ending pipes.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// How long the first record of a batch may wait for others, unless set otherwise.
+const defaultBatchWindow = 200 * time.Millisecond
+
+// How often the translation memory is saved while streaming; saving it after every batch
+// would mean rewriting all of it several times per second.
+const streamSaveInterval = 30 * time.Second
+
+// Translates records (usually lines) as they arrive, instead of waiting for the end of the input.
+// Records are put together into batches, sent as soon as there are size of them, or as soon as
+// the first one has waited for window; translations come out in the same order as the records.
+// The translation memory, if any, is saved every now and then, at the end of the input, and
+// when the stream is interrupted.
+type streamer struct {
+	t			*translator
+	size		int
+	window		time.Duration
+	delimiter	string	// Ends each record, e.g. "\n"; written back after each translation.
+	out			io.Writer
+	interrupt	<-chan os.Signal	// Stops the stream; nil if it cannot be interrupted.
+}
+
+// Returns a channel that receives the first SIGINT or SIGTERM. Only the first one is caught:
+// a second one ends the program at once, even in the middle of a request.
+func notifyInterrupt() <-chan os.Signal {
+	caught := make(chan os.Signal, 1)
+	signal.Notify(caught, os.Interrupt, syscall.SIGTERM)
+	first := make(chan os.Signal, 1)
+	go func() {
+		sig := <-caught
+		signal.Stop(caught)
+		first <- sig
+	}()
+	return first
+}
+
+// Reads records from r until the end of the input, translating them on the way.
+func (s *streamer) run(r io.Reader) (err error) {
+	records := make(chan string, s.size)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(records)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 1024*1024)
+		scanner.Split(scanRecords(s.delimiter))
+		for scanner.Scan() {
+			// empty records are records too.
+			records <- scanner.Text()
+		}
+		if err := scanner.Err(); err != nil {
+			readErr <- err
+		}
+	}()
+
+	var save <-chan time.Time
+	if s.t.memory != nil {
+		ticker := time.NewTicker(streamSaveInterval)
+		defer ticker.Stop()
+		save = ticker.C
+		// however the stream ends, nothing already translated is lost.
+		defer func() {
+			if saveErr := s.t.memory.save(); err == nil {
+				err = saveErr
+			}
+		}()
+	}
+
+	var batch []string
+	var timeout <-chan time.Time	// Set while there is a batch.
+	for {
+		select {
+			case record, ok := <-records:
+				if !ok {
+					if err := s.flush(batch); err != nil {
+						return err
+					}
+					select {
+						case err := <-readErr:
+							return fmt.Errorf("%s (occurred while reading the stream)", err)
+						default:
+							return nil
+					}
+				}
+				batch = append(batch, record)
+				if len(batch) == 1 {
+					timeout = time.After(s.window)
+				}
+				if len(batch) < s.size {
+					continue
+				}
+			case <-timeout:
+			case <-save:
+				if err := s.t.memory.save(); err != nil {
+					return err
+				}
+				continue
+			case sig := <-s.interrupt:
+				// whatever is still in the batch is dropped.
+				code := 130
+				if n, ok := sig.(syscall.Signal); ok {
+					code = 128 + int(n)
+				}
+				return cli.Exit(fmt.Sprintf("stopped by %s", sig), code)
+		}
+		if err := s.flush(batch); err != nil {
+			return err
+		}
+		batch, timeout = nil, nil
+	}
+}
+
+// Translates a batch of records, and writes the translations out.
+func (s *streamer) flush(batch []string) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	inputs := make([]translationInput, len(batch))
+	ends := make([]string, len(batch))
+	for i, record := range batch {
+		text, found := strings.CutSuffix(record, s.delimiter)
+		if found {
+			ends[i] = s.delimiter
+		}
+		inputs[i] = translationInput{Name: stdinName, Text: text}
+	}
+	plan, err := s.t.plan(inputs)
+	if err != nil {
+		return err
+	}
+	outputs, err := s.t.execute(plan)
+	if err != nil {
+		return err
+	}
+	for i, output := range outputs {
+		if _, err := io.WriteString(s.out, output+ends[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
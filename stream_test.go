@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+	"github.com/urfave/cli/v2"
+)
+
+// Collects what is written, for reading while it is being written.
+type syncBuffer struct {
+	mu	sync.Mutex
+	b	strings.Builder
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+// Waits until b has exactly expected, or gives up after a while.
+func waitForOutput(t *testing.T, b *syncBuffer, expected string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for b.String() != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected output: %q\nActual: %q", expected, b.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStreamer(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		requests = append(requests, strings.Join(r.PostForm["text"], "|"))
+		mu.Unlock()
+		var response deepl.DeepLResponse
+		for _, text := range r.PostForm["text"] {
+			response.Translations = append(response.Translations, deepl.Translated{DetectedSourceLanguage: "EN", Text: strings.ToUpper(text)})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	memoryPath := filepath.Join(t.TempDir(), "memory.json")
+	memory, _ := loadMemory(memoryPath)
+	in, pipe := io.Pipe()
+	var out syncBuffer
+	s := streamer{
+		t:			&translator{client: &deepl.DeepLClient{Endpoint: server.URL, TargetLang: "DE"}, memory: memory},
+		size:		3,
+		window:		50 * time.Millisecond,
+		delimiter:	"\n",
+		out:		&out,
+	}
+	done := make(chan error)
+	go func() { done <- s.run(in) }()
+
+	// lines come out once the window is over, without waiting for the end of the input.
+	io.WriteString(pipe, "first\n\n  second\r\n")
+	waitForOutput(t, &out, "FIRST\n\n  SECOND\r\n")
+	// the memory is not rewritten after every batch...
+	if _, err := os.Stat(memoryPath); err == nil {
+		t.Errorf("The translation memory should not have been saved yet")
+	}
+	// a full batch goes at once, whatever the window.
+	s.window = time.Hour
+	io.WriteString(pipe, "third\nfourth\nfirst\n")
+	waitForOutput(t, &out, "FIRST\n\n  SECOND\r\nTHIRD\nFOURTH\nFIRST\n")
+	// and so does the end of the input, even without a final newline.
+	io.WriteString(pipe, "last")
+	pipe.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := "FIRST\n\n  SECOND\r\nTHIRD\nFOURTH\nFIRST\nLAST"; out.String() != expected {
+		t.Errorf("Expected output: %q\nActual: %q", expected, out.String())
+	}
+	// ... but it is at the end of the stream.
+	if saved, err := loadMemory(memoryPath); err != nil || len(saved.Entries) != 5 {
+		t.Errorf("Expected the translation memory to be saved with 5 entries, got %v", err)
+	}
+	// the second "first" comes from the memory.
+	if expected := "first|second,third|fourth,last"; strings.Join(requests, ",") != expected {
+		t.Errorf("Expected requests: %s\nActual: %s", expected, strings.Join(requests, ","))
+	}
+}
+
+func TestStreamerInterrupt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		json.NewEncoder(w).Encode(deepl.DeepLResponse{Translations: []deepl.Translated{{Text: strings.ToUpper(r.PostForm.Get("text"))}}})
+	}))
+	defer server.Close()
+
+	memoryPath := filepath.Join(t.TempDir(), "memory.json")
+	memory, _ := loadMemory(memoryPath)
+	in, pipe := io.Pipe()
+	defer pipe.Close()
+	interrupt := make(chan os.Signal, 1)
+	var out syncBuffer
+	s := streamer{
+		t:			&translator{client: &deepl.DeepLClient{Endpoint: server.URL, TargetLang: "DE"}, memory: memory},
+		size:		10,
+		window:		time.Millisecond,
+		delimiter:	"\n",
+		out:		&out,
+		interrupt:	interrupt,
+	}
+	done := make(chan error)
+	go func() { done <- s.run(in) }()
+
+	io.WriteString(pipe, "first\n")
+	waitForOutput(t, &out, "FIRST\n")
+	interrupt <- syscall.SIGINT
+	var exitErr cli.ExitCoder
+	if err := <-done; !errors.As(err, &exitErr) || exitErr.ExitCode() != 130 {
+		t.Errorf("Expected exit code 130, got %v", err)
+	}
+	if saved, err := loadMemory(memoryPath); err != nil || len(saved.Entries) != 1 {
+		t.Errorf("Expected the translation memory to be saved on interruption, got %v", err)
+	}
+}
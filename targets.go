@@ -0,0 +1,252 @@
+// This file translates the same inputs into several target languages at once.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Splits a list of target languages separated by commas, e.g. "DE, fr,JA".
+func splitTargets(list string) []string {
+	var targets []string
+	for _, target := range strings.Split(list, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// Checks the language pairs of the settings, like resolveLanguages, when the target language may be
+// a list separated by commas. Returns the target languages, without repetitions; the settings are
+// left with the first one.
+func (s *Setting) resolveTargets(lists *languageLists, warn io.Writer) ([]string, error) {
+	codes := splitTargets(s.TargetLang)
+	if len(codes) <= 1 {
+		if len(codes) == 1 {
+			s.TargetLang = codes[0]
+		}
+		if err := s.resolveLanguages(lists, warn); err != nil {
+			return nil, err
+		}
+		return []string{s.TargetLang}, nil
+	}
+	var targets []string
+	seen := make(map[string]bool)
+	source := s.SourceLang
+	for _, code := range codes {
+		changed := *s
+		changed.TargetLang = code
+		if err := changed.resolveLanguages(lists, warn); err != nil {
+			return nil, err
+		}
+		// without the lists of languages, DeepL gets to check the codes.
+		source, code = changed.SourceLang, strings.ToUpper(changed.TargetLang)
+		if !seen[code] {
+			seen[code] = true
+			targets = append(targets, code)
+		}
+	}
+	s.SourceLang, s.TargetLang = source, targets[0]
+	return targets, nil
+}
+
+// Returns one translator per target language, the first one being ts.t. They all share the same
+// connection, guard and translation memory, and each one gets the glossary for its language pair.
+func (ts *translationSetup) translators() ([]*translator, error) {
+	result := []*translator{ts.t}
+	for _, target := range ts.targets[1:] {
+		client := *ts.t.client
+		client.TargetLang, client.GlossaryID = target, ""
+		if ts.glossary != "" && ts.glossaries != nil {
+			glossaries := *ts.glossaries
+			glossaries.TargetLang = target
+			id, err := resolveGlossary(&glossaries, ts.glossary)
+			if err != nil {
+				return nil, err
+			}
+			client.GlossaryID = id
+		}
+		t := *ts.t
+		t.client = &client
+		result = append(result, &t)
+	}
+	return result, nil
+}
+
+// Puts the plans for several target languages together, for estimating them all at once;
+// each input appears once per target language.
+func combinePlans(plans []*translationPlan, targets []string) *translationPlan {
+	if len(plans) == 1 {
+		return plans[0]
+	}
+	combined := &translationPlan{}
+	for i, p := range plans {
+		for j, input := range p.inputs {
+			input.Name = fmt.Sprintf("%s ⇒ %s", input.Name, targets[i])
+			combined.inputs = append(combined.inputs, input)
+			combined.segments = append(combined.segments, p.segments[j])
+			combined.billable = append(combined.billable, p.billable[j])
+			combined.characters = append(combined.characters, p.characters[j])
+		}
+	}
+	return combined
+}
+
+// Executes the plans concurrently, each with its own translator, and returns the outputs of each
+// one. The outputs of the plans that failed are nil, and the first error is returned as well;
+// whatever was translated has been paid for, so it is not thrown away.
+func executeAll(translators []*translator, plans []*translationPlan) ([][]string, error) {
+	outputs, errs := executeEach(translators, plans)
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if len(plans) == 1 {
+			return outputs, err
+		}
+		message := fmt.Sprintf("%s (occurred while translating into %s)", err, translators[i].client.TargetLang)
+		if exitCoder, ok := err.(cli.ExitCoder); ok {
+			// keep the exit status, e.g. for an exceeded budget.
+			return outputs, cli.Exit(message, exitCoder.ExitCode())
+		}
+		return outputs, fmt.Errorf("%s", message)
+	}
+	return outputs, nil
+}
+
+// Executes the plans concurrently, each with its own translator; returns the outputs and the error
+// of each one.
+func executeEach(translators []*translator, plans []*translationPlan) ([][]string, []error) {
+	outputs := make([][]string, len(plans))
+	errs := make([]error, len(plans))
+	var wg sync.WaitGroup
+	for i := range plans {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			outputs[i], errs[i] = translators[i].execute(plans[i])
+		}(i)
+	}
+	wg.Wait()
+	return outputs, errs
+}
+
+// Returns the path of the translation of an input into a target language, as given by template:
+// {dir}, {name} and {ext} are the directory, base name and extension of the input file (".",
+// "stdin" and nothing for STDIN), and {lang} and {LANG} the target language, in lower and upper case.
+func outputPath(template string, input string, target string) string {
+	dir, name, ext := ".", "stdin", ""
+	if input != stdinName {
+		dir = filepath.Dir(input)
+		base := filepath.Base(input)
+		ext = filepath.Ext(base)
+		name = strings.TrimSuffix(base, ext)
+	}
+	return strings.NewReplacer(
+		"{dir}",	dir,
+		"{name}",	name,
+		"{ext}",	ext,
+		"{lang}",	strings.ToLower(target),
+		"{LANG}",	strings.ToUpper(target),
+	).Replace(template)
+}
+
+// Returns the output paths for each target language and input, making sure that no two translations
+// end up in the same file, and that no input gets overwritten.
+func outputPaths(template string, inputs []translationInput, targets []string) ([][]string, error) {
+	taken := make(map[string]string)
+	for _, input := range inputs {
+		if input.Name != stdinName {
+			taken[filepath.Clean(input.Name)] = "the input file " + input.Name
+		}
+	}
+	paths := make([][]string, len(targets))
+	for i, target := range targets {
+		for _, input := range inputs {
+			path := outputPath(template, input.Name, target)
+			what := fmt.Sprintf("the translation of %s into %s", input.Name, target)
+			if other, ok := taken[filepath.Clean(path)]; ok {
+				return nil, fmt.Errorf("the output template %q gives the same file, %s, for %s and %s; use {lang} and {name} to tell them apart", template, path, other, what)
+			}
+			taken[filepath.Clean(path)] = what
+			paths[i] = append(paths[i], path)
+		}
+	}
+	return paths, nil
+}
+
+// Writes each translation into its own file; nil outputs (failed translations) are skipped.
+func writeOutputs(paths [][]string, outputs [][]string) error {
+	for i := range paths {
+		if outputs[i] == nil {
+			continue
+		}
+		for j, path := range paths[i] {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, []byte(outputs[i][j]), 0644); err != nil {
+				return err
+			}
+			if debugLevel > 0 {
+				fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+			}
+		}
+	}
+	return nil
+}
+
+// Writes the translations of several inputs one after the other, each under a header with the
+// name of its input (as `head` does), so that they neither run together nor get mixed up. In
+// record mode, the delimiter is what keeps the records of different inputs apart instead.
+func printTranslations(w io.Writer, inputs []translationInput, outputs []string, delimiter string) {
+	for i, output := range outputs {
+		switch {
+			case delimiter != "":
+				if i > 0 && !strings.HasSuffix(outputs[i-1], delimiter) {
+					fmt.Fprint(w, delimiter)
+				}
+			case len(outputs) > 1:
+				if i > 0 {
+					if !strings.HasSuffix(outputs[i-1], "\n") {
+						fmt.Fprintln(w)
+					}
+					fmt.Fprintln(w)
+				}
+				fmt.Fprintf(w, "==> %s <==\n", inputs[i].Name)
+		}
+		fmt.Fprint(w, output)
+	}
+}
+
+// Writes the translations as one JSON object keyed by target language. With several inputs, each
+// language gets an object keyed by input file instead of the translation itself.
+// Nil outputs (failed translations) are left out.
+func printTranslationsJSON(w io.Writer, inputs []translationInput, targets []string, outputs [][]string) error {
+	result := make(map[string]interface{}, len(targets))
+	for i, target := range targets {
+		if outputs[i] == nil {
+			continue
+		}
+		if len(inputs) == 1 {
+			result[target] = outputs[i][0]
+			continue
+		}
+		byInput := make(map[string]string, len(inputs))
+		for j, input := range inputs {
+			byInput[input.Name] = outputs[i][j]
+		}
+		result[target] = byInput
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+)
+
+func TestResolveTargets(t *testing.T) {
+	setting := Setting{SourceLang: "german", TargetLang: "ja, en-gb,JA,,Portuguese (Brazilian)"}
+	targets, err := setting.resolveTargets(testLanguages, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := "JA EN-GB PT-BR"; strings.Join(targets, " ") != expected {
+		t.Errorf("Expected targets: %s\nActual: %s", expected, strings.Join(targets, " "))
+	}
+	if setting.SourceLang != "DE" || setting.TargetLang != "JA" {
+		t.Errorf("Expected the settings to be left with DE ⇒ JA, got %s ⇒ %s", setting.SourceLang, setting.TargetLang)
+	}
+
+	setting = Setting{SourceLang: "DE", TargetLang: "JA,XX"}
+	if _, err := setting.resolveTargets(testLanguages, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), `"XX"`) {
+		t.Errorf("Expected an error about XX, got %v", err)
+	}
+}
+
+func TestOutputPaths(t *testing.T) {
+	inputs := []translationInput{{Name: "docs/notes.md"}, {Name: "README"}}
+	paths, err := outputPaths("{dir}/{name}.{lang}{ext}", inputs, []string{"DE", "ZH-HANS"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "docs/notes.de.md ./README.de docs/notes.zh-hans.md ./README.zh-hans"
+	if actual := strings.Join(append(paths[0], paths[1]...), " "); actual != expected {
+		t.Errorf("Expected paths: %s\nActual: %s", expected, actual)
+	}
+	if path := outputPath("out/{LANG}/{name}.txt", stdinName, "pt-br"); path != "out/PT-BR/stdin.txt" {
+		t.Errorf("Unexpected path for STDIN: %s", path)
+	}
+
+	for _, template := range []string{"{dir}/{name}{ext}", "{name}.txt"} {
+		if _, err := outputPaths(template, inputs, []string{"DE", "FR"}); err == nil {
+			t.Errorf("Expected template %q to be rejected", template)
+		}
+	}
+}
+
+func TestPrintTranslations(t *testing.T) {
+	inputs := []translationInput{{Name: "a.txt"}, {Name: "b.txt"}}
+	for _, c := range []struct {
+		outputs		[]string
+		delimiter	string
+		expected	string
+	}{
+		{[]string{"Eins"}, "", "Eins"},
+		{[]string{"Eins", "Zwei\n"}, "", "==> a.txt <==\nEins\n\n==> b.txt <==\nZwei\n"},
+		{[]string{"Eins\x00", "Zwei"}, "\x00", "Eins\x00Zwei"},
+		{[]string{"Eins", "Zwei"}, "\x00", "Eins\x00Zwei"},
+	} {
+		var out bytes.Buffer
+		printTranslations(&out, inputs[:len(c.outputs)], c.outputs, c.delimiter)
+		if out.String() != c.expected {
+			t.Errorf("Expected output: %q\nActual: %q", c.expected, out.String())
+		}
+	}
+}
+
+func TestMultipleTargets(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		target := r.PostForm.Get("target_lang")
+		mu.Lock()
+		requests[target]++
+		mu.Unlock()
+		if target == "FR" {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		var response deepl.DeepLResponse
+		for _, text := range r.PostForm["text"] {
+			response.Translations = append(response.Translations, deepl.Translated{DetectedSourceLanguage: "EN", Text: target + ": " + text})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	memory, _ := loadMemory(filepath.Join(t.TempDir(), "memory.json"))
+	ts := &translationSetup{
+		t:			&translator{client: &deepl.DeepLClient{Endpoint: server.URL, SourceLang: "EN", TargetLang: "DE"}, memory: memory},
+		targets:	[]string{"DE", "FR", "JA"},
+	}
+	translators, err := ts.translators()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	inputs := []translationInput{{Name: "a.txt", Text: "Hello."}, {Name: "b.txt", Text: "Bye."}}
+	plans := make([]*translationPlan, len(translators))
+	for i, tr := range translators {
+		if plans[i], err = tr.plan(inputs); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	if total := combinePlans(plans, ts.targets).totalBillable(); total != 3*10 {
+		t.Errorf("Expected 30 billable characters in all, got %d", total)
+	}
+
+	outputs, err := executeAll(translators, plans)
+	if err == nil || !strings.Contains(err.Error(), "translating into FR") {
+		t.Errorf("Expected an error about FR, got %v", err)
+	}
+	if requests["DE"] != 1 || requests["JA"] != 1 {
+		t.Errorf("Expected one request per language, got %v", requests)
+	}
+	if _, ok := memory.exact("EN", "JA", "Bye."); !ok {
+		t.Errorf("Expected the translations to be remembered for each language")
+	}
+
+	var b bytes.Buffer
+	if err := printTranslationsJSON(&b, inputs, ts.targets, outputs); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	var result map[string]map[string]string
+	if err := json.Unmarshal(b.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid JSON: %s\n%s", err, b.String())
+	}
+	if _, ok := result["FR"]; ok || result["DE"]["b.txt"] != "DE: Bye." || result["JA"]["a.txt"] != "JA: Hello." {
+		t.Errorf("Unexpected JSON output:\n%s", b.String())
+	}
+
+	// whatever was translated still gets written.
+	dir := t.TempDir()
+	paths, err := outputPaths(filepath.Join(dir, "{name}.{lang}{ext}"), inputs, ts.targets)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := writeOutputs(paths, outputs); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "a.ja.txt")); err != nil || string(data) != "JA: Hello." {
+		t.Errorf("Unexpected a.ja.txt: %q (%v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.fr.txt")); err == nil {
+		t.Errorf("Expected no file for the failed translation")
+	}
+}
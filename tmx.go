@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Formats supported by `tm import` and `tm export`.
+const (
+	memoryFormatJSON = "json"
+	memoryFormatTMX  = "tmx"
+)
+
+// Date format used by TMX (ISO 8601, basic format, always UTC).
+const tmxDateFormat = "20060102T150405Z"
+
+// Property used to round-trip the origin of each entry through CAT tools.
+const tmxOriginProp = "x-origin"
+
+// Properties used to round-trip the options each entry was translated with.
+const (
+	tmxFormalityProp	= "x-formality"
+	tmxGlossaryProp		= "x-glossary-id"
+	tmxContextProp		= "x-context"
+	tmxTagHandlingProp	= "x-tag-handling"
+)
+
+// The subset of TMX 1.4 that we read and write.
+// SEE: https://www.gala-global.org/tmx-14b
+type tmxDocument struct {
+	XMLName	xml.Name	`xml:"tmx"`
+	Version	string		`xml:"version,attr"`
+	Header	tmxHeader	`xml:"header"`
+	Units	[]tmxUnit	`xml:"body>tu"`
+}
+
+type tmxHeader struct {
+	CreationTool		string	`xml:"creationtool,attr"`
+	CreationToolVersion	string	`xml:"creationtoolversion,attr"`
+	SegType				string	`xml:"segtype,attr"`
+	OTMF				string	`xml:"o-tmf,attr"`
+	AdminLang			string	`xml:"adminlang,attr"`
+	SrcLang				string	`xml:"srclang,attr"`
+	DataType			string	`xml:"datatype,attr"`
+}
+
+type tmxUnit struct {
+	SrcLang			string		`xml:"srclang,attr,omitempty"`
+	CreationDate	string		`xml:"creationdate,attr,omitempty"`
+	ChangeDate		string		`xml:"changedate,attr,omitempty"`
+	Props			[]tmxProp	`xml:"prop"`
+	Variants		[]tmxVariant	`xml:"tuv"`
+}
+
+type tmxProp struct {
+	Type	string	`xml:"type,attr"`
+	Value	string	`xml:",chardata"`
+}
+
+type tmxVariant struct {
+	Lang	string	`xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Seg		tmxSeg	`xml:"seg"`
+}
+
+// A segment may contain inline markup (<bpt>, <ph>...), which we have to skip when reading.
+type tmxSeg struct {
+	Inner	string	`xml:",innerxml"`
+}
+
+// Target languages for which DeepL distinguishes regional variants.
+var regionalTargets = map[string]bool{
+	"EN-GB": true, "EN-US": true, "PT-BR": true, "PT-PT": true, "ZH-HANS": true, "ZH-HANT": true,
+}
+
+// Converts a TMX language tag (e.g. "de-DE") into a DeepL language code (e.g. "DE").
+// Source languages never have a region; target languages only keep it if DeepL knows about it.
+func deeplLangFromTMX(tag string, source bool) string {
+	code := strings.ToUpper(strings.ReplaceAll(tag, "_", "-"))
+	if !source && regionalTargets[code] {
+		return code
+	}
+	base, _, _ := strings.Cut(code, "-")
+	return base
+}
+
+// Writes the whole memory as a TMX document.
+func (m *translationMemory) exportTMX(w io.Writer) error {
+	doc := tmxDocument{
+		Version: "1.4",
+		Header: tmxHeader{
+			CreationTool:			"deepl-translate-cli",
+			CreationToolVersion:	versionInfo.version,
+			SegType:				"sentence",
+			OTMF:					"deepl-translate-cli",
+			AdminLang:				"en",
+			SrcLang:				"*all*",
+			DataType:				"plaintext",
+		},
+	}
+	srcLangs := make(map[string]bool)
+	for _, e := range m.Entries {
+		srcLangs[e.SourceLang] = true
+		props := []tmxProp{{Type: tmxOriginProp, Value: e.Origin}}
+		for _, prop := range []tmxProp{
+			{Type: tmxFormalityProp, Value: e.Formality},
+			{Type: tmxGlossaryProp, Value: e.GlossaryID},
+			{Type: tmxContextProp, Value: e.Context},
+			{Type: tmxTagHandlingProp, Value: e.TagHandling},
+		} {
+			if prop.Value != "" {
+				props = append(props, prop)
+			}
+		}
+		doc.Units = append(doc.Units, tmxUnit{
+			SrcLang:		e.SourceLang,
+			CreationDate:	e.Created.UTC().Format(tmxDateFormat),
+			ChangeDate:		e.Updated.UTC().Format(tmxDateFormat),
+			Props:			props,
+			Variants: []tmxVariant{
+				{Lang: e.SourceLang, Seg: tmxSeg{Inner: escapeXML(e.Source)}},
+				{Lang: e.TargetLang, Seg: tmxSeg{Inner: escapeXML(e.Target)}},
+			},
+		})
+	}
+	if len(srcLangs) == 1 {
+		for lang := range srcLangs {
+			doc.Header.SrcLang = lang
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Reads a TMX document and returns its segment pairs as memory entries.
+// Units with more than one target language become several entries.
+func parseTMX(r io.Reader) ([]memoryEntry, error) {
+	var doc tmxDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%s (occurred while parsing TMX)", err.Error())
+	}
+	var entries []memoryEntry
+	for i, tu := range doc.Units {
+		srcLang := tu.SrcLang
+		if srcLang == "" {
+			srcLang = doc.Header.SrcLang
+		}
+		if len(tu.Variants) < 2 {
+			continue
+		}
+		// find the source variant; if the source language is unknown, it's the first one.
+		src := 0
+		if srcLang != "" && srcLang != "*all*" {
+			src = -1
+			for j, tuv := range tu.Variants {
+				if strings.EqualFold(tuv.Lang, srcLang) {
+					src = j
+					break
+				}
+			}
+			if src < 0 {
+				return nil, fmt.Errorf("translation unit #%d has no variant in its source language %s", i+1, srcLang)
+			}
+		}
+		source, err := tu.Variants[src].Seg.text()
+		if err != nil {
+			return nil, fmt.Errorf("%s (occurred while reading translation unit #%d)", err.Error(), i+1)
+		}
+		origin := ""
+		var options memoryEntry
+		for _, prop := range tu.Props {
+			switch prop.Type {
+				case tmxOriginProp:
+					origin = strings.TrimSpace(prop.Value)
+				case tmxFormalityProp:
+					options.Formality = strings.TrimSpace(prop.Value)
+				case tmxGlossaryProp:
+					options.GlossaryID = strings.TrimSpace(prop.Value)
+				case tmxContextProp:
+					options.Context = prop.Value
+				case tmxTagHandlingProp:
+					options.TagHandling = strings.TrimSpace(prop.Value)
+			}
+		}
+		created, _ := time.Parse(tmxDateFormat, tu.CreationDate)
+		updated, _ := time.Parse(tmxDateFormat, tu.ChangeDate)
+		for j, tuv := range tu.Variants {
+			if j == src {
+				continue
+			}
+			target, err := tuv.Seg.text()
+			if err != nil {
+				return nil, fmt.Errorf("%s (occurred while reading translation unit #%d)", err.Error(), i+1)
+			}
+			entries = append(entries, memoryEntry{
+				SourceLang:		deeplLangFromTMX(tu.Variants[src].Lang, true),
+				TargetLang:		deeplLangFromTMX(tuv.Lang, false),
+				Formality:		options.Formality,
+				GlossaryID:		options.GlossaryID,
+				Context:		options.Context,
+				TagHandling:	options.TagHandling,
+				Source:			source,
+				Target:			target,
+				Origin:			origin,
+				Created:		created,
+				Updated:		updated,
+			})
+		}
+	}
+	return entries, nil
+}
+
+// Extracts the plain text of a segment, dropping the native codes inside inline elements.
+func (s tmxSeg) text() (string, error) {
+	decoder := xml.NewDecoder(strings.NewReader("<seg>" + s.Inner + "</seg>"))
+	var b strings.Builder
+	skip := 0 // depth inside elements whose content is not text
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+			case xml.StartElement:
+				switch t.Name.Local {
+					case "bpt", "ept", "ph", "it", "ut":
+						skip++
+				}
+			case xml.EndElement:
+				switch t.Name.Local {
+					case "bpt", "ept", "ph", "it", "ut":
+						skip--
+				}
+			case xml.CharData:
+				if skip == 0 {
+					b.Write(t)
+				}
+		}
+	}
+	return b.String(), nil
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
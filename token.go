@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+)
+
+// Where one API token comes from, for pools of keys; the first one set is used.
+type tokenSource struct {
+	Name			string	`json:"name,omitempty"`				// For reporting, e.g. who owns the key.
+	TokenEnv		string	`json:"token_env,omitempty"`
+	TokenFile		string	`json:"token_file,omitempty"`
+	TokenCommand	string	`json:"token_command,omitempty"`
+	IsPro			bool	`json:"pro,omitempty"`					// Always use the Pro plan's endpoint?
+	IsFree			bool	`json:"free,omitempty"`				// Always use the Free plan's endpoint?
+}
+
+// Looks up the token, returning it along with where it came from.
+func (t tokenSource) load() (string, string, error) {
+	switch {
+		case t.TokenEnv != "":
+			return os.Getenv(t.TokenEnv), "environment " + t.TokenEnv, nil
+		case t.TokenFile != "":
+			token, err := readTokenFile(t.TokenFile)
+			return token, "file " + t.TokenFile, err
+		case t.TokenCommand != "":
+			token, err := runTokenCommand(t.TokenCommand)
+			return token, "command " + t.TokenCommand, err
+		default:
+			return os.Getenv("DEEPL_TOKEN"), "environment DEEPL_TOKEN", nil
+	}
+}
+
+// Finds the API token, from the first of these that is configured: the environment variable
+// named by token_env, the file named by token_file, the output of token_command, and finally
+// the DEEPL_TOKEN environment variable. The token is only looked up once.
+// If a pool of keys is configured, each one is looked up, and the first one becomes the token.
+func (s *Setting) loadAuthKey() error {
+	if s.AuthKey != "" {
+		return nil
+	}
+	if len(s.Keys) > 0 {
+		return s.loadKeyPool()
+	}
+	token, source, err := tokenSource{TokenEnv: s.TokenEnv, TokenFile: s.TokenFile, TokenCommand: s.TokenCommand}.load()
+	if err != nil {
+		return err
+	}
+	s.AuthKey = token
+	if debugLevel > 0 {
+		fmt.Fprintf(os.Stderr, "DeepL token taken from %s\n", source)
+	}
+	return s.requireAuthKey()
+}
+
+// Looks up all the keys of the pool; those that cannot be found are skipped.
+func (s *Setting) loadKeyPool() error {
+	var keys []deepl.PoolKey
+	for i, source := range s.Keys {
+		name := source.Name
+		if name == "" {
+			name = fmt.Sprintf("key %d", i+1)
+		}
+		token, origin, err := source.load()
+		if err == nil && token == "" {
+			err = fmt.Errorf("%s is not set", origin)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s of the pool: %s\n", name, err)
+			continue
+		}
+		if debugLevel > 0 {
+			fmt.Fprintf(os.Stderr, "DeepL token for %s taken from %s\n", name, origin)
+		}
+		key := deepl.PoolKey{Name: name, AuthKey: token}
+		switch {
+			case source.IsPro || (!source.IsFree && s.IsPro):
+				key.BaseURL = deepl.GetEndpoint(true)
+			case source.IsFree || s.IsFree:
+				key.BaseURL = deepl.GetEndpoint(false)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("none of the %d keys in the pool could be found", len(s.Keys))
+	}
+	s.AuthKey = keys[0].AuthKey
+	s.keyPool = deepl.NewKeyPool(keys)
+	return nil
+}
+
+// Reads the token from a file, which must not be accessible by anyone else
+// (where file permissions mean something).
+func readTokenFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read the DeepL token: %s", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("refusing to read the DeepL token from %s: it is accessible by others (mode %04o); run `chmod 600 %s` first", path, info.Mode().Perm(), path)
+	}
+	token, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read the DeepL token: %s", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// Runs a credential helper through the shell, taking its output as the token.
+// What it writes to STDERR (e.g. a prompt for a passphrase) is shown to the user.
+func runTokenCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("token_command %q failed: %s", command, err)
+	}
+	token := strings.TrimSpace(stdout.String())
+	if token == "" {
+		return "", fmt.Errorf("token_command %q did not output a token", command)
+	}
+	return token, nil
+}
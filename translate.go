@@ -0,0 +1,420 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+	"github.com/mattn/go-isatty"
+	"github.com/urfave/cli/v2"
+)
+
+// Name used for input coming from STDIN.
+const stdinName = "-"
+
+// One text to be translated, either from a file or from STDIN.
+type translationInput struct {
+	Name	string	// File path, or "-" for STDIN.
+	Text	string
+}
+
+// Interactive mode is for when no files are given, and STDIN is a terminal.
+func isInteractive(c *cli.Context) bool {
+	return c.NArg() == 0 && (isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd()))
+}
+
+// Reads all inputs given on the command line; if there are none, reads STDIN to the end.
+func readInputs(c *cli.Context) ([]translationInput, error) {
+	if c.NArg() == 0 {
+		// no filename path passed; read from STDIN (a pipe; a TTY gets the interactive mode instead)
+		pipeIn, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		return []translationInput{{Name: stdinName, Text: string(pipeIn)}}, nil
+	}
+
+	var inputs []translationInput
+	for _, path := range c.Args().Slice() {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, translationInput{Name: path, Text: string(b)})
+	}
+	return inputs, nil
+}
+
+// Everything the translate and tui commands work with, set up from the settings.
+type translationSetup struct {
+	t			*translator
+	glossaries	*deepl.DeepLClient	// For resolving glossary names; nil without a token.
+	langs		*languageLists		// For checking language codes; nil if they are not known.
+	usage		func() (deepl.DeepLUsageResponse, error)	// Live account usage: of the token, or of the whole pool.
+	targets		[]string	// Target languages; t translates into the first one.
+	glossary	string		// Glossary ID or name, to be resolved for each target language.
+}
+
+// Loads the token, checks the language pair, resolves the glossary, and sets up the translator with
+// the budgets and the translation memory, as the settings say. All clients share one connection.
+// If the token is not required (e.g. for a dry run), its absence is just a warning.
+func setupTranslation(setting *Setting, dryRun bool) (*translationSetup, error) {
+	if err := setting.loadAuthKey(); err != nil {
+		if !dryRun {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "%s; the remaining allowance will not be checked\n", err)
+	}
+	// typos in the language codes are caught here, instead of by DeepL.
+	langs, err := supportedLanguages(*setting)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot retrieve the supported languages (%s); the language codes will not be checked\n", err)
+	}
+	targets, err := setting.resolveTargets(langs, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	client := &deepl.DeepLClient{
+		Endpoint: 			setting.endpoint() + "/translate",
+		AuthKey:			setting.AuthKey,
+		Pool:				setting.keyPool,
+		SourceLang:			setting.SourceLang,
+		TargetLang:			setting.TargetLang,
+		IsPro:				setting.isPro(),
+		TagHandling:		setting.TagHandling,
+		SplitSentences:		setting.SplitSentences,
+		PreserveFormatting:	setting.PreserveFormatting,
+		OutlineDetection:	setting.OutlineDetection,
+		NonSplittingTags:	setting.NonSplittingTags,
+		SplittingTags:		setting.SplittingTags,
+		IgnoreTags:			setting.IgnoreTags,
+		Formality:			setting.Formality,
+		HTTPClient:			&http.Client{},
+		Debug:				debugLevel,
+	}
+	ts := &translationSetup{langs: langs, targets: targets, glossary: setting.Glossary}
+	if setting.AuthKey != "" {
+		glossaryClient := *client
+		glossaryClient.Endpoint = setting.endpoint() + "/glossaries"
+		ts.glossaries = &glossaryClient
+		if setting.Glossary != "" {
+			if client.GlossaryID, err = resolveGlossary(ts.glossaries, setting.Glossary); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	ledger, err := openLedger(*setting)
+	if err != nil {
+		return nil, err
+	}
+	usageClient := *client
+	usageClient.Endpoint = setting.endpoint() + "/usage"
+	usageClient.Pool = nil
+	ts.usage = usageClient.Usage
+	if setting.keyPool != nil {
+		// what is left is whatever is left on all the keys.
+		pool := setting.keyPool
+		ts.usage = func() (deepl.DeepLUsageResponse, error) {
+			return poolUsageTotal(pool, debugLevel)
+		}
+	}
+	guard, err := newQuotaGuard(setting.Budget, setting.Project, ledgerUser(*setting), ledger, ts.usage)
+	if err != nil {
+		return nil, err
+	}
+
+	ts.t = &translator{
+		client:		client,
+		guard:		guard,
+		preserve:	setting.PreserveWhitespace,
+		options: memoryOptions{
+			SegmentBy:	setting.SegmentBy,
+			Threshold:	setting.FuzzyThreshold,
+			UseFuzzy:	setting.UseFuzzy,
+		},
+	}
+	if setting.UseMemory {
+		if ts.t.memory, err = openMemory(*setting); err != nil {
+			return nil, err
+		}
+	}
+	return ts, nil
+}
+
+// The translator sits between the translate command and DeepLClient.Translate: it splits
+// the inputs into segments, takes whatever it can from the translation memory (if any),
+// and sends everything else to DeepL, without repetitions, in as few requests as possible.
+type translator struct {
+	client	*deepl.DeepLClient
+	memory	*translationMemory	// nil if the translation memory is not being used.
+	guard	*quotaGuard			// nil if spending is neither checked nor recorded.
+	options	memoryOptions
+
+	preserve	bool		// Give translations the whitespace and line endings of their sources?
+	readOnly	bool		// Only take translations from the memory, without adding new ones to it?
+	info		io.Writer	// Reports of fuzzy matches and debugging; nil means os.Stderr.
+
+	detected	string	// Source language detected by DeepL in the last execution, if anything was sent.
+}
+
+// What needs to be done to translate a set of inputs.
+type translationPlan struct {
+	inputs		[]translationInput
+	segments	[][]segment			// Segments of each input.
+	known		map[string]string	// Translations already available, by segment.
+	missing		[]string			// Unique segments that must be sent to DeepL, in order of appearance.
+	owner		map[string]int		// Input where each missing segment first appeared, which gets billed for it.
+	billable	[]int				// Billable characters for each input (repetitions count only once).
+	characters	[]int				// Characters in each input, including repetitions and known segments.
+	detected	map[string]string	// Source language detected for each segment sent, once executed.
+}
+
+// Splits the inputs and decides which segments have to go to DeepL.
+func (t *translator) plan(inputs []translationInput) (*translationPlan, error) {
+	p := &translationPlan{
+		inputs:		inputs,
+		known:		make(map[string]string),
+		owner:		make(map[string]int),
+		detected:	make(map[string]string),
+	}
+	seen := make(map[string]bool)
+	for i, input := range inputs {
+		var segments []segment
+		if t.memory != nil {
+			segmentBy := t.options.SegmentBy
+			if t.client.TagHandling != "" {
+				// splitting structured text would break the tags apart.
+				segmentBy = segmentByNone
+			}
+			var err error
+			if segments, err = splitSegments(input.Text, segmentBy); err != nil {
+				return nil, err
+			}
+		} else {
+			segments = chunkText(input.Text, deepl.MaxTextsSize)
+		}
+		p.segments = append(p.segments, segments)
+
+		billable, characters := 0, 0
+		for _, s := range segments {
+			if s.Text == "" {
+				continue
+			}
+			characters += billableCharacters(s.Text)
+			if seen[s.Text] {
+				continue
+			}
+			seen[s.Text] = true
+			if translation, ok := t.lookup(s.Text); ok {
+				p.known[s.Text] = translation
+				continue
+			}
+			p.missing = append(p.missing, s.Text)
+			p.owner[s.Text] = i
+			billable += billableCharacters(s.Text)
+		}
+		p.billable = append(p.billable, billable)
+		p.characters = append(p.characters, characters)
+	}
+	return p, nil
+}
+
+func (t *translator) infoWriter() io.Writer {
+	if t.info == nil {
+		return os.Stderr
+	}
+	return t.info
+}
+
+// Looks up a segment in the translation memory; fuzzy matches are either used or just reported.
+func (t *translator) lookup(text string) (string, bool) {
+	if t.memory == nil {
+		return "", false
+	}
+	query := t.memoryEntry(text)
+	if e, ok := t.memory.find(query); ok {
+		return e.Target, true
+	}
+	threshold := t.options.Threshold
+	if threshold <= 0 {
+		threshold = defaultFuzzyThreshold
+	}
+	matches := t.memory.similar(query, threshold, 1)
+	if len(matches) == 0 {
+		return "", false
+	}
+	best := matches[0]
+	if t.options.UseFuzzy {
+		fmt.Fprintf(t.infoWriter(), "Using fuzzy match (%.0f%%) for %q\n", best.Score*100, text)
+		return best.Entry.Target, true
+	}
+	fmt.Fprintf(t.infoWriter(), "Fuzzy match (%.0f%%) for %q: %q ⇒ %q\n", best.Score*100, text, best.Entry.Source, best.Entry.Target)
+	return "", false
+}
+
+// Returns a memory entry for a segment, in the scope of the languages and options of the client;
+// translations made with other options are never used.
+func (t *translator) memoryEntry(source string) memoryEntry {
+	return memoryEntry{
+		SourceLang:		t.client.SourceLang,
+		TargetLang:		t.client.TargetLang,
+		Formality:		t.client.Formality,
+		GlossaryID:		t.client.GlossaryID,
+		Context:		t.client.Context,
+		TagHandling:	t.client.TagHandling,
+		Source:			source,
+	}
+}
+
+// Sends the missing segments to DeepL, and returns the translation of each input.
+func (t *translator) execute(p *translationPlan) ([]string, error) {
+	t.detected = ""
+	translations := make(map[string]string, len(p.known)+len(p.missing))
+	for source, target := range p.known {
+		translations[source] = target
+	}
+	for _, batch := range deepl.BatchTexts(p.missing) {
+		characters := 0
+		for _, text := range batch {
+			characters += billableCharacters(text)
+		}
+		if t.guard != nil {
+			if err := t.guard.check(characters); err != nil {
+				return nil, err
+			}
+		}
+		translateds, err := t.client.TranslateTexts(batch)
+		if err != nil {
+			if t.guard != nil {
+				t.guard.release(characters)
+			}
+			return nil, err
+		}
+		if t.guard != nil {
+			if err := t.recordSpending(p, batch, translateds); err != nil {
+				return nil, err
+			}
+		}
+		if len(translateds) > 0 {
+			t.detected = translateds[0].DetectedSourceLanguage
+		}
+		for i, translated := range translateds {
+			if t.preserve {
+				translated.Text = preserveWhitespace(batch[i], translated.Text)
+			}
+			translations[batch[i]] = translated.Text
+			p.detected[batch[i]] = translated.DetectedSourceLanguage
+			if t.memory != nil && !t.readOnly {
+				e := t.memoryEntry(batch[i])
+				e.Target = translated.Text
+				e.Origin = originMachine
+				t.memory.add(e)
+			}
+		}
+	}
+	if debugLevel > 0 {
+		fmt.Fprintf(t.infoWriter(), "%d unique segments, %d sent to DeepL\n", len(translations), len(p.missing))
+	}
+
+	outputs := make([]string, len(p.inputs))
+	for i, segments := range p.segments {
+		outputs[i] = joinSegments(segments, translations)
+	}
+	return outputs, nil
+}
+
+// Records a successful request in the ledger, with one record per input file involved
+// (records read from the same stream count as one).
+func (t *translator) recordSpending(p *translationPlan, batch []string, translateds []deepl.Translated) error {
+	sourceLang := t.client.SourceLang
+	if sourceLang == "" && len(translateds) > 0 {
+		sourceLang = translateds[0].DetectedSourceLanguage
+	}
+	request := newRequestID()
+	perInput := make(map[string]int)
+	var order []string
+	for _, text := range batch {
+		name := p.inputs[p.owner[text]].Name
+		if _, ok := perInput[name]; !ok {
+			order = append(order, name)
+		}
+		perInput[name] += billableCharacters(text)
+	}
+	for _, name := range order {
+		err := t.guard.record(ledgerRecord{
+			SourceLang:	sourceLang,
+			TargetLang:	t.client.TargetLang,
+			File:		name,
+			Characters:	perInput[name],
+			Request:	request,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the source language detected for an input: that of its first segment sent to DeepL.
+// Empty if nothing of it was sent.
+func (p *translationPlan) detectedLanguage(i int) string {
+	for _, s := range p.segments[i] {
+		if lang := p.detected[s.Text]; s.Text != "" && lang != "" {
+			return lang
+		}
+	}
+	return ""
+}
+
+// Total billable characters of the plan.
+func (p *translationPlan) totalBillable() int {
+	total := 0
+	for _, n := range p.billable {
+		total += n
+	}
+	return total
+}
+
+// DeepL bills the number of Unicode code points sent, whatever the script.
+func billableCharacters(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+// Prints the per-input and total estimate of a plan, and checks it against the remaining allowance.
+func printEstimate(w io.Writer, p *translationPlan, usage *deepl.DeepLUsageResponse) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Input\tCharacters\tSegments\tBillable\t")
+	for i, input := range p.inputs {
+		segments := 0
+		for _, s := range p.segments[i] {
+			if s.Text != "" {
+				segments++
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t\n", input.Name, p.characters[i], segments, p.billable[i])
+	}
+	total := p.totalBillable()
+	fmt.Fprintf(tw, "Total\t\t\t%d\t\n", total)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if usage == nil {
+		return nil
+	}
+	if usage.CharacterLimit == 0 {
+		fmt.Fprintf(w, "No character limit for this account.\n")
+		return nil
+	}
+	remaining := usage.CharacterLimit - usage.CharacterCount
+	fmt.Fprintf(w, "Remaining allowance: %d of %d characters.\n", remaining, usage.CharacterLimit)
+	if total > remaining {
+		return fmt.Errorf("the estimated %d characters exceed the remaining allowance of %d characters by %d", total, remaining, total-remaining)
+	}
+	fmt.Fprintf(w, "This fits; %d characters would be left afterwards.\n", remaining-total)
+	return nil
+}
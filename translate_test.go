@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+)
+
+func TestChunkText(t *testing.T) {
+	small := "  A small text.\n"
+	chunks := chunkText(small, 1000)
+	if len(chunks) != 2 || chunks[1].Text != "A small text." {
+		t.Errorf("Small texts should be a single chunk, without the surrounding whitespace: %#v", chunks)
+	}
+
+	paragraph := strings.Repeat("This is a sentence. ", 10)
+	large := strings.Repeat(paragraph+"\n\n", 5) + strings.Repeat("x", 250)
+	for _, limit := range []int{100, 300, 1000} {
+		chunks := chunkText(large, limit)
+		identity := make(map[string]string)
+		for _, c := range chunks {
+			if encodedSize(c.Text) > limit {
+				t.Errorf("Chunk larger than %d bytes: %q", limit, c.Text)
+			}
+			identity[c.Text] = c.Text
+		}
+		if joined := joinSegments(chunks, identity); joined != large {
+			t.Errorf("Chunking with limit %d is not reversible", limit)
+		}
+	}
+}
+
+func TestTranslationPlan(t *testing.T) {
+	memory, _ := loadMemory(t.TempDir() + "/memory.json")
+	memory.add(memoryEntry{SourceLang: "EN", TargetLang: "DE", Source: "Known.", Target: "Bekannt.", Origin: originHuman})
+
+	tr := translator{
+		client:		&deepl.DeepLClient{SourceLang: "EN", TargetLang: "DE"},
+		memory:		memory,
+		options:	memoryOptions{SegmentBy: segmentBySentence, Threshold: 1},
+	}
+	plan, err := tr.plan([]translationInput{
+		{Name: "a", Text: "Known. Привет. Hello."},
+		{Name: "b", Text: "Hello. New one."},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	// "Known." comes from the memory, "Hello." is sent only once, and Cyrillic counts one per letter.
+	expected := []int{7 + 6, 8}
+	for i, n := range expected {
+		if plan.billable[i] != n {
+			t.Errorf("Billable characters of input %d\nExpected: %d\nActual: %d", i, n, plan.billable[i])
+		}
+	}
+	if len(plan.missing) != 3 {
+		t.Errorf("Expected 3 segments to be sent, got %#v", plan.missing)
+	}
+	if plan.known["Known."] != "Bekannt." {
+		t.Errorf("Expected the known segment to come from the memory, got %#v", plan.known)
+	}
+}
+
+func TestMemoryKeepsOptionsApart(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		requests++
+		var response deepl.DeepLResponse
+		for _, text := range r.PostForm["text"] {
+			response.Translations = append(response.Translations, deepl.Translated{DetectedSourceLanguage: "EN", Text: r.PostForm.Get("formality") + ":" + text})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	memory, _ := loadMemory(t.TempDir() + "/memory.json")
+	translate := func(formality string) string {
+		tr := translator{
+			client:		&deepl.DeepLClient{Endpoint: server.URL, SourceLang: "EN", TargetLang: "DE", Formality: formality},
+			memory:		memory,
+			options:	memoryOptions{SegmentBy: segmentByNone, Threshold: 1},
+		}
+		plan, err := tr.plan([]translationInput{{Name: "a", Text: "How are you?"}})
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		outputs, err := tr.execute(plan)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		return outputs[0]
+	}
+	for _, c := range []struct{ formality, expected string }{
+		{"more", "more:How are you?"},
+		{"less", "less:How are you?"},
+		{"more", "more:How are you?"},
+	} {
+		if actual := translate(c.formality); actual != c.expected {
+			t.Errorf("Expected %q with formality %s, got %q", c.expected, c.formality, actual)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("Expected one request per formality, got %d", requests)
+	}
+	if len(memory.Entries) != 2 || memory.Entries[0].Formality != "more" || memory.Entries[1].Formality != "less" {
+		t.Errorf("Expected one entry per formality, got %#v", memory.Entries)
+	}
+	if _, ok := memory.exact("EN", "DE", "How are you?"); ok {
+		t.Errorf("Entries with a formality should not match lookups without one")
+	}
+}
@@ -0,0 +1,451 @@
+// This file implements the tui command: a full-screen editor, with the translation beside the text.
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+)
+
+// How long to wait after the last keystroke before translating, unless set otherwise.
+const defaultDebounce = 600 * time.Millisecond
+
+// Formality values, in the order in which the tui cycles through them.
+var formalityCycle = []string{"default", "more", "less", "prefer_more", "prefer_less"}
+
+// Events posted to the event loop, as the data of a tcell.EventInterrupt.
+type (
+	tuiTranslate	struct{ generation int }	// Time to translate the text as of generation.
+	tuiTranslated	struct {					// The translation of the text as of generation.
+		generation	int
+		text		string
+		detected	string	// Source language, as detected if it was not set.
+		billed		int
+		byDeepL		string	// Source language detected by DeepL, if anything was sent.
+		err			error
+	}
+	tuiUsage		struct {					// The account usage, as retrieved.
+		usage	deepl.DeepLUsageResponse
+		err		error
+	}
+	tuiMessage		string						// A warning, for the message line.
+)
+
+// Something being asked on the bottom line, e.g. a language.
+type tuiPrompt struct {
+	label	string
+	command	string	// Session command run with the answer.
+	input	[]rune
+}
+
+// The full-screen editor. The event loop owns everything, the session included: translations
+// run in the background on a copy of the session (see snapshot), and post their results back,
+// so that nothing waits for DeepL but the translations themselves.
+type tui struct {
+	screen		tcell.Screen
+	debounce	time.Duration
+	usage		func() (deepl.DeepLUsageResponse, error)	// nil if the usage cannot be retrieved.
+	session		session
+	running		sync.WaitGroup	// Translations in the background.
+
+	text		[]rune	// Being edited.
+	cursor		int		// Position in text.
+	generation	int		// Incremented with every change that requires a new translation.
+	timer		*time.Timer
+	translation	string
+	detected	string
+	pending		bool	// Is the translation outdated?
+	message		string	// Last warning or error.
+	prompt		*tuiPrompt
+	lastBilled	int
+	totalBilled	int
+	lastUsage	*deepl.DeepLUsageResponse
+}
+
+// Writes session warnings to the message line. They may come from the translations running
+// in the background, so they go through the event loop.
+type tuiMessages struct{ u *tui }
+
+func (m tuiMessages) Write(p []byte) (int, error) {
+	m.u.screen.PostEvent(tcell.NewEventInterrupt(tuiMessage(strings.TrimSpace(string(p)))))
+	return len(p), nil
+}
+
+func newTUI(screen tcell.Screen, s session, usage func() (deepl.DeepLUsageResponse, error), debounce time.Duration) *tui {
+	u := &tui{screen: screen, session: s, usage: usage, debounce: debounce}
+	// anything written to the terminal would garble the screen.
+	u.session.info = tuiMessages{u}
+	u.session.t.info = u.session.info
+	u.session.t.client.Log = u.session.info
+	if u.session.glossaries != nil {
+		u.session.glossaries.Log = u.session.info
+	}
+	// the text is translated over and over while it is being typed; the drafts do not belong
+	// in the translation memory.
+	u.session.t.readOnly = true
+	return u
+}
+
+// Runs the event loop until the user quits.
+func (u *tui) run() error {
+	u.refreshUsage()
+	for {
+		u.draw()
+		switch ev := u.screen.PollEvent().(type) {
+			case nil:
+				// the screen was finalised.
+				return nil
+			case *tcell.EventResize:
+				u.screen.Sync()
+			case *tcell.EventKey:
+				if quit := u.key(ev); quit {
+					if u.timer != nil {
+						u.timer.Stop()
+					}
+					// wait for whatever is being translated, so that it gets recorded.
+					u.running.Wait()
+					return nil
+				}
+			case *tcell.EventInterrupt:
+				u.interrupt(ev.Data())
+		}
+	}
+}
+
+// Handles a key; returns true to quit.
+func (u *tui) key(ev *tcell.EventKey) bool {
+	if u.prompt != nil {
+		u.promptKey(ev)
+		return false
+	}
+	switch ev.Key() {
+		case tcell.KeyEscape, tcell.KeyCtrlC, tcell.KeyCtrlQ:
+			return true
+		case tcell.KeyF2:
+			u.prompt = &tuiPrompt{label: "Source language (empty to detect it)", command: ":source"}
+		case tcell.KeyF3:
+			u.prompt = &tuiPrompt{label: "Target language", command: ":target"}
+		case tcell.KeyF4:
+			u.command(":swap", "")
+		case tcell.KeyF5:
+			current, next := u.session.setting.Formality, formalityCycle[1]
+			for i, value := range formalityCycle {
+				if value == current {
+					next = formalityCycle[(i+1)%len(formalityCycle)]
+				}
+			}
+			u.command(":formality", next)
+		case tcell.KeyF6:
+			u.prompt = &tuiPrompt{label: "Glossary (empty for none)", command: ":glossary", input: []rune(u.session.setting.Glossary)}
+		case tcell.KeyRune:
+			u.insert(ev.Rune())
+		case tcell.KeyEnter:
+			u.insert('\n')
+		case tcell.KeyTab:
+			u.insert('\t')
+		case tcell.KeyBackspace, tcell.KeyBackspace2:
+			if u.cursor > 0 {
+				u.text = append(u.text[:u.cursor-1], u.text[u.cursor:]...)
+				u.cursor--
+				u.changed()
+			}
+		case tcell.KeyDelete:
+			if u.cursor < len(u.text) {
+				u.text = append(u.text[:u.cursor], u.text[u.cursor+1:]...)
+				u.changed()
+			}
+		case tcell.KeyLeft:
+			u.cursor = max(u.cursor-1, 0)
+		case tcell.KeyRight:
+			u.cursor = min(u.cursor+1, len(u.text))
+		case tcell.KeyHome, tcell.KeyCtrlA:
+			u.cursor = u.lineStart(u.cursor)
+		case tcell.KeyEnd, tcell.KeyCtrlE:
+			u.cursor = u.lineEnd(u.cursor)
+		case tcell.KeyUp:
+			if start := u.lineStart(u.cursor); start > 0 {
+				prev := u.lineStart(start - 1)
+				u.cursor = min(prev+u.cursor-start, start-1)
+			}
+		case tcell.KeyDown:
+			if end := u.lineEnd(u.cursor); end < len(u.text) {
+				u.cursor = min(end+1+u.cursor-u.lineStart(u.cursor), u.lineEnd(end+1))
+			}
+	}
+	return false
+}
+
+// Handles a key while something is being asked.
+func (u *tui) promptKey(ev *tcell.EventKey) {
+	p := u.prompt
+	switch ev.Key() {
+		case tcell.KeyEscape, tcell.KeyCtrlC:
+			u.prompt = nil
+		case tcell.KeyEnter:
+			u.prompt = nil
+			u.command(p.command, strings.TrimSpace(string(p.input)))
+		case tcell.KeyBackspace, tcell.KeyBackspace2:
+			if len(p.input) > 0 {
+				p.input = p.input[:len(p.input)-1]
+			}
+		case tcell.KeyRune:
+			p.input = append(p.input, ev.Rune())
+	}
+}
+
+func (u *tui) lineStart(i int) int {
+	for i > 0 && u.text[i-1] != '\n' {
+		i--
+	}
+	return i
+}
+
+func (u *tui) lineEnd(i int) int {
+	for i < len(u.text) && u.text[i] != '\n' {
+		i++
+	}
+	return i
+}
+
+func (u *tui) insert(r rune) {
+	u.text = append(u.text[:u.cursor], append([]rune{r}, u.text[u.cursor:]...)...)
+	u.cursor++
+	u.changed()
+}
+
+// The text changed: it gets translated once the typing stops for a while.
+func (u *tui) changed() {
+	u.generation++
+	u.pending = true
+	if u.timer != nil {
+		u.timer.Stop()
+	}
+	generation := u.generation
+	u.timer = time.AfterFunc(u.debounce, func() {
+		u.screen.PostEvent(tcell.NewEventInterrupt(tuiTranslate{generation}))
+	})
+}
+
+// Runs a session command, and translates again at once with the new settings.
+func (u *tui) command(command string, arg string) {
+	u.message = ""
+	if err := u.session.command(command, arg); err != nil {
+		u.message = err.Error()
+		return
+	}
+	if u.timer != nil {
+		u.timer.Stop()
+	}
+	u.generation++
+	u.pending = true
+	u.interrupt(tuiTranslate{u.generation})
+}
+
+// Handles the events posted by the timers and the background work.
+func (u *tui) interrupt(data interface{}) {
+	switch ev := data.(type) {
+		case tuiTranslate:
+			if ev.generation != u.generation {
+				// there was more typing since.
+				return
+			}
+			text := string(u.text)
+			if strings.TrimSpace(text) == "" {
+				u.translation, u.detected, u.pending = "", "", false
+				return
+			}
+			s := u.session.snapshot()
+			u.running.Add(1)
+			go func() {
+				defer u.running.Done()
+				translation, detected, billed, err := s.translate(text)
+				u.screen.PostEvent(tcell.NewEventInterrupt(tuiTranslated{ev.generation, translation, detected, billed, s.t.detected, err}))
+			}()
+		case tuiTranslated:
+			// billed even if the text has changed since.
+			u.lastBilled = ev.billed
+			u.totalBilled += ev.billed
+			if ev.err != nil {
+				u.message = ev.err.Error()
+			}
+			if ev.generation == u.generation {
+				if ev.err == nil {
+					u.translation, u.detected = ev.text, ev.detected
+					// for :swap.
+					u.session.t.detected = ev.byDeepL
+				}
+				u.pending = false
+			}
+			if ev.billed > 0 {
+				u.refreshUsage()
+			}
+		case tuiMessage:
+			u.message = string(ev)
+		case tuiUsage:
+			if ev.err != nil {
+				u.message = fmt.Sprintf("cannot retrieve the usage: %s", ev.err)
+				return
+			}
+			u.lastUsage = &ev.usage
+	}
+}
+
+// Returns a copy of the session for translating in the background, which the event loop cannot
+// change under its feet; the memory and the quota guard are shared, and safe to share.
+func (s session) snapshot() session {
+	setting, t := *s.setting, *s.t
+	client := *t.client
+	t.client = &client
+	s.setting, s.t = &setting, &t
+	return s
+}
+
+// Retrieves the account usage in the background.
+func (u *tui) refreshUsage() {
+	if u.usage == nil {
+		return
+	}
+	go func() {
+		usage, err := u.usage()
+		u.screen.PostEvent(tcell.NewEventInterrupt(tuiUsage{usage, err}))
+	}()
+}
+
+// Lays text out in lines of at most width columns; also returns where the cursor ends up.
+func layoutText(text []rune, width int, cursor int) ([][]rune, int, int) {
+	lines := [][]rune{nil}
+	x, cx, cy := 0, 0, 0
+	for i, r := range text {
+		w := runewidth.RuneWidth(r)
+		if r != '\n' && x+w > width && x > 0 {
+			lines = append(lines, nil)
+			x = 0
+		}
+		if i == cursor {
+			cx, cy = x, len(lines)-1
+		}
+		if r == '\n' {
+			lines = append(lines, nil)
+			x = 0
+			continue
+		}
+		if r == '\t' {
+			r, w = ' ', 1
+		}
+		lines[len(lines)-1] = append(lines[len(lines)-1], r)
+		x += w
+	}
+	if cursor >= len(text) {
+		cx, cy = x, len(lines)-1
+		if x >= width && width > 0 {
+			cx, cy = 0, len(lines)
+		}
+	}
+	return lines, cx, cy
+}
+
+// Writes s at (x, y), up to width columns; returns the column after it.
+func (u *tui) print(x, y, width int, style tcell.Style, s string) int {
+	end := x + width
+	for _, r := range s {
+		w := runewidth.RuneWidth(r)
+		if x+w > end {
+			break
+		}
+		u.screen.SetContent(x, y, r, nil, style)
+		x += w
+	}
+	return x
+}
+
+// Draws lines in a pane, starting from line offset.
+func (u *tui) drawPane(x, y, width, height int, lines [][]rune, offset int, style tcell.Style) {
+	for row := 0; row < height && offset+row < len(lines); row++ {
+		u.print(x, y+row, width, style, string(lines[offset+row]))
+	}
+}
+
+func (u *tui) draw() {
+	u.screen.Clear()
+	width, height := u.screen.Size()
+	if width < 20 || height < 5 {
+		u.print(0, 0, width, tcell.StyleDefault, "Too small!")
+		u.screen.Show()
+		return
+	}
+	bar := tcell.StyleDefault.Reverse(true)
+	dim := tcell.StyleDefault.Dim(true)
+
+	// header: the settings in use.
+	setting := u.session.setting
+	source := setting.SourceLang
+	if source == "" {
+		source = "auto"
+		if u.detected != "" {
+			source = "auto: " + u.detected
+		}
+	}
+	formality, glossary := setting.Formality, setting.Glossary
+	if formality == "" {
+		formality = "default"
+	}
+	if glossary == "" {
+		glossary = "none"
+	}
+	for x := 0; x < width; x++ {
+		u.screen.SetContent(x, 0, ' ', nil, bar)
+	}
+	u.print(1, 0, width-1, bar, fmt.Sprintf("%s ⇒ %s · formality: %s · glossary: %s", source, setting.TargetLang, formality, glossary))
+
+	// the panes, side by side, scrolled so that the cursor can be seen.
+	paneHeight := height - 3
+	left := (width - 1) / 2
+	right := width - left - 1
+	sourceLines, cx, cy := layoutText(u.text, left, u.cursor)
+	offset := max(0, cy-paneHeight+1)
+	u.drawPane(0, 1, left, paneHeight, sourceLines, offset, tcell.StyleDefault)
+	for y := 1; y <= paneHeight; y++ {
+		u.screen.SetContent(left, y, '│', nil, dim)
+	}
+	translationLines, _, _ := layoutText([]rune(strings.TrimRight(u.translation, "\n")), right, 0)
+	style := tcell.StyleDefault
+	if u.pending {
+		style = dim
+	}
+	u.drawPane(left+1, 1, right, paneHeight, translationLines, min(offset, max(0, len(translationLines)-paneHeight)), style)
+
+	// what it costs.
+	status := fmt.Sprintf("Billed: %d characters (this session: %d)", u.lastBilled, u.totalBilled)
+	if u.lastUsage != nil {
+		if u.lastUsage.CharacterLimit > 0 {
+			status += fmt.Sprintf(" · Remaining: %d of %d", u.lastUsage.CharacterLimit-u.lastUsage.CharacterCount, u.lastUsage.CharacterLimit)
+		} else {
+			status += " · No character limit"
+		}
+	}
+	for x := 0; x < width; x++ {
+		u.screen.SetContent(x, height-2, ' ', nil, bar)
+	}
+	u.print(1, height-2, width-1, bar, status)
+
+	// the bottom line: a question, a message, or the keys.
+	u.screen.HideCursor()
+	switch {
+		case u.prompt != nil:
+			x := u.print(0, height-1, width, tcell.StyleDefault, u.prompt.label+": "+string(u.prompt.input))
+			u.screen.ShowCursor(x, height-1)
+		case u.message != "":
+			u.print(0, height-1, width, tcell.StyleDefault.Bold(true), u.message)
+		default:
+			u.print(0, height-1, width, dim, "F2 source  F3 target  F4 swap  F5 formality  F6 glossary  Esc quit")
+	}
+	if u.prompt == nil && cy-offset < paneHeight {
+		u.screen.ShowCursor(cx, 1+cy-offset)
+	}
+	u.screen.Show()
+}
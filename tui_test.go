@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+	"github.com/gdamore/tcell/v2"
+)
+
+// Returns the text shown on the screen, one line per row.
+func screenText(screen tcell.SimulationScreen) string {
+	cells, width, _ := screen.GetContents()
+	var b strings.Builder
+	for i, cell := range cells {
+		if i > 0 && i%width == 0 {
+			b.WriteByte('\n')
+		}
+		if len(cell.Runes) > 0 {
+			b.WriteRune(cell.Runes[0])
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+// A simulated screen whose text can be read while the tui keeps drawing on it.
+type shownScreen struct {
+	tcell.SimulationScreen
+	mu		sync.Mutex
+	shown	string	// As of the last Show.
+}
+
+func (s *shownScreen) Show() {
+	s.SimulationScreen.Show()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shown = screenText(s.SimulationScreen)
+}
+
+func (s *shownScreen) text() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shown
+}
+
+// Waits until the screen shows all of expected, or gives up after a while.
+func waitForScreen(t *testing.T, screen *shownScreen, expected ...string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		text := screen.text()
+		missing := ""
+		for _, e := range expected {
+			if !strings.Contains(text, e) {
+				missing = e
+				break
+			}
+		}
+		if missing == "" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %q on the screen:\n%s", missing, text)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTUI(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		requests = append(requests, r.PostForm.Get("target_lang")+":"+r.PostForm.Get("formality")+":"+strings.Join(r.PostForm["text"], "|"))
+		mu.Unlock()
+		var response deepl.DeepLResponse
+		for _, text := range r.PostForm["text"] {
+			response.Translations = append(response.Translations, deepl.Translated{DetectedSourceLanguage: "EN", Text: strings.ToUpper(text)})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	screen := &shownScreen{SimulationScreen: tcell.NewSimulationScreen("UTF-8")}
+	if err := screen.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer screen.Fini()
+	screen.SetSize(80, 10)
+
+	setting := defaultSettings()
+	setting.SourceLang = ""
+	client := deepl.DeepLClient{Endpoint: server.URL, TargetLang: "JA"}
+	// fuzzy matches are reported on the message line, and drafts are not remembered.
+	memory, _ := loadMemory(t.TempDir() + "/memory.json")
+	memory.add(memoryEntry{TargetLang: "JA", Source: "hellos", Target: "やあ", Origin: originHuman})
+	tr := translator{client: &client, memory: memory, options: memoryOptions{SegmentBy: segmentBySentence, Threshold: 0.5}}
+	usage := func() (deepl.DeepLUsageResponse, error) {
+		return deepl.DeepLUsageResponse{CharacterCount: 100, CharacterLimit: 500000}, nil
+	}
+	u := newTUI(screen, session{setting: &setting, t: &tr, langs: testLanguages}, usage, 10*time.Millisecond)
+	done := make(chan error)
+	go func() { done <- u.run() }()
+
+	for _, r := range "hello" {
+		screen.InjectKey(tcell.KeyRune, r, tcell.ModNone)
+	}
+	waitForScreen(t, screen, "hello", "HELLO", "auto: EN ⇒ JA", "Billed: 5 characters", "Remaining: 499900 of 500000", "Fuzzy match")
+
+	// a new pair and formality translate again at once.
+	screen.InjectKey(tcell.KeyF3, 0, tcell.ModNone)
+	for _, r := range "german" {
+		screen.InjectKey(tcell.KeyRune, r, tcell.ModNone)
+	}
+	screen.InjectKey(tcell.KeyEnter, 0, tcell.ModNone)
+	screen.InjectKey(tcell.KeyF5, 0, tcell.ModNone)
+	waitForScreen(t, screen, "⇒ DE · formality: more", "this session: 15")
+
+	// so do errors.
+	screen.InjectKey(tcell.KeyF6, 0, tcell.ModNone)
+	for _, r := range "terms" {
+		screen.InjectKey(tcell.KeyRune, r, tcell.ModNone)
+	}
+	screen.InjectKey(tcell.KeyEnter, 0, tcell.ModNone)
+	waitForScreen(t, screen, "glossaries cannot be used")
+
+	screen.InjectKey(tcell.KeyEscape, 0, tcell.ModNone)
+	select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("The tui did not quit")
+	}
+
+	if _, ok := memory.exact("", "JA", "hello"); ok {
+		t.Errorf("A draft was added to the translation memory")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// the second translation may or may not start before the formality changes, and the last two
+	// run at the same time.
+	if len(requests) != 3 || requests[0] != "JA::hello" || (requests[1] != "DE:more:hello" && requests[2] != "DE:more:hello") {
+		t.Errorf("Expected requests: JA::hello, then DE:…:hello and DE:more:hello in any order\nActual: %s", strings.Join(requests, "|"))
+	}
+}
+
+func TestTUIDrawsWhileTranslating(t *testing.T) {
+	release := make(chan struct{})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		// a slow DeepL: nothing comes back until the test says so.
+		<-release
+		var response deepl.DeepLResponse
+		for _, text := range r.PostForm["text"] {
+			response.Translations = append(response.Translations, deepl.Translated{DetectedSourceLanguage: "EN", Text: strings.ToUpper(text)})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+	defer unblock()
+
+	screen := &shownScreen{SimulationScreen: tcell.NewSimulationScreen("UTF-8")}
+	if err := screen.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer screen.Fini()
+	screen.SetSize(80, 10)
+
+	setting := defaultSettings()
+	setting.SourceLang = ""
+	tr := translator{client: &deepl.DeepLClient{Endpoint: server.URL, TargetLang: "JA"}}
+	u := newTUI(screen, session{setting: &setting, t: &tr, langs: testLanguages}, nil, 10*time.Millisecond)
+	done := make(chan error)
+	go func() { done <- u.run() }()
+
+	screen.InjectKey(tcell.KeyRune, 'a', tcell.ModNone)
+	// give the translation time to start, and to get stuck.
+	time.Sleep(100 * time.Millisecond)
+	// typing and changing the settings go on while DeepL is being asked.
+	for _, r := range "bc" {
+		screen.InjectKey(tcell.KeyRune, r, tcell.ModNone)
+	}
+	screen.InjectKey(tcell.KeyF5, 0, tcell.ModNone)
+	waitForScreen(t, screen, "abc", "formality: more")
+
+	unblock()
+	waitForScreen(t, screen, "ABC")
+	screen.InjectKey(tcell.KeyEscape, 0, tcell.ModNone)
+	select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("The tui did not quit")
+	}
+}
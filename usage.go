@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+	"github.com/urfave/cli/v2"
+)
+
+// Exit codes of the `usage` command when a threshold is reached, e.g. for monitoring scripts.
+const (
+	exitUsageWarning	= 4	// Usage reached --warn-at.
+	exitUsageCritical	= 5	// Usage reached --fail-at.
+)
+
+// Width of the percentage bars, in characters.
+const usageBarWidth = 30
+
+// One line of the usage report.
+type usageRow struct {
+	Key		string	`json:"-"`	// Key in the JSON output.
+	Label	string	`json:"-"`	// Label in the human-readable output.
+	Count	int		`json:"count"`
+	Limit	int		`json:"limit"`
+	Percent	float64	`json:"percent"`
+}
+
+// Converts the usage into rows, skipping whatever the account does not have (i.e., has no limit for).
+func usageRows(u deepl.DeepLUsageResponse) []usageRow {
+	var rows []usageRow
+	add := func(key, label string, count, limit int) {
+		if limit <= 0 {
+			return
+		}
+		rows = append(rows, usageRow{
+			Key:		key,
+			Label:		label,
+			Count:		count,
+			Limit:		limit,
+			Percent:	100 * float64(count) / float64(limit),
+		})
+	}
+	add("characters", "Characters", u.CharacterCount, u.CharacterLimit)
+	add("documents", "Documents", u.DocumentCount, u.DocumentLimit)
+	add("team_documents", "Team documents", u.TeamDocumentCount, u.TeamDocumentLimit)
+	return rows
+}
+
+// Writes the usage report, either as JSON or as a table with percentage bars.
+func printUsage(w io.Writer, rows []usageRow, output string) error {
+	switch output {
+		case outputJSON:
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(usageReport(rows))
+		case outputTable, "":
+			if len(rows) == 0 {
+				_, err := fmt.Fprintln(w, "No limits are set for this account.")
+				return err
+			}
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			for _, row := range rows {
+				fmt.Fprintf(tw, "%s\t%d / %d\t%s\t%5.1f%%\n", row.Label, row.Count, row.Limit, usageBar(row.Percent), row.Percent)
+			}
+			return tw.Flush()
+		default:
+			return fmt.Errorf("output must be either `table` or `json` (got: %s)", output)
+	}
+}
+
+// Rows keyed as in the JSON output.
+func usageReport(rows []usageRow) map[string]usageRow {
+	report := make(map[string]usageRow, len(rows))
+	for _, row := range rows {
+		report[row.Key] = row
+	}
+	return report
+}
+
+// Usage of one key of a pool.
+type keyUsage struct {
+	Name	string
+	Usage	deepl.DeepLUsageResponse
+	Err		error	// If the usage could not be retrieved.
+}
+
+// Retrieves the usage of every key in the pool, along with their total
+// (which leaves out the keys whose usage could not be retrieved).
+func poolUsage(pool *deepl.KeyPool, debug int) ([]keyUsage, deepl.DeepLUsageResponse) {
+	var keys []keyUsage
+	var total deepl.DeepLUsageResponse
+	for _, key := range pool.Keys {
+		client := deepl.DeepLClient{
+			Endpoint:	key.Endpoint() + "/usage",
+			AuthKey:	key.AuthKey,
+			Debug:		debug,
+		}
+		usage, err := client.Usage()
+		keys = append(keys, keyUsage{Name: key.Name, Usage: usage, Err: err})
+		if err != nil {
+			continue
+		}
+		total.CharacterCount += usage.CharacterCount
+		total.CharacterLimit += usage.CharacterLimit
+		total.DocumentCount += usage.DocumentCount
+		total.DocumentLimit += usage.DocumentLimit
+		total.TeamDocumentCount += usage.TeamDocumentCount
+		total.TeamDocumentLimit += usage.TeamDocumentLimit
+	}
+	return keys, total
+}
+
+// Returns what is used and left on all the keys of a pool together, or an error if the usage
+// of none of them could be retrieved: a total of nothing would look like no limit at all.
+func poolUsageTotal(pool *deepl.KeyPool, debug int) (deepl.DeepLUsageResponse, error) {
+	keys, total := poolUsage(pool, debug)
+	for _, key := range keys {
+		if key.Err == nil {
+			return total, nil
+		}
+	}
+	if len(keys) == 0 {
+		return total, fmt.Errorf("the pool has no keys")
+	}
+	return total, fmt.Errorf("could not retrieve the usage of any of the %d keys in the pool: %s", len(keys), keys[0].Err)
+}
+
+// Writes the usage report for a pool of keys, one per key and then the total;
+// either as JSON or as tables with percentage bars.
+func printPoolUsage(w io.Writer, keys []keyUsage, total []usageRow, output string) error {
+	switch output {
+		case outputJSON:
+			type keyReport struct {
+				Name	string				`json:"name"`
+				Usage	map[string]usageRow	`json:"usage,omitempty"`
+				Error	string				`json:"error,omitempty"`
+			}
+			report := struct {
+				Keys	[]keyReport			`json:"keys"`
+				Total	map[string]usageRow	`json:"total"`
+			}{Total: usageReport(total)}
+			for _, key := range keys {
+				if key.Err != nil {
+					report.Keys = append(report.Keys, keyReport{Name: key.Name, Error: key.Err.Error()})
+				} else {
+					report.Keys = append(report.Keys, keyReport{Name: key.Name, Usage: usageReport(usageRows(key.Usage))})
+				}
+			}
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(report)
+		case outputTable, "":
+			for _, key := range keys {
+				fmt.Fprintln(w, key.Name)
+				if key.Err != nil {
+					fmt.Fprintf(w, "Could not retrieve the usage: %s\n", key.Err)
+				} else if err := printUsage(w, usageRows(key.Usage), output); err != nil {
+					return err
+				}
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintln(w, "Total")
+			return printUsage(w, total, output)
+		default:
+			return fmt.Errorf("output must be either `table` or `json` (got: %s)", output)
+	}
+}
+
+// Draws a bar filled in proportion to percent.
+func usageBar(percent float64) string {
+	filled := int(percent / 100 * usageBarWidth + 0.5)
+	filled = min(max(filled, 0), usageBarWidth)
+	return "[" + strings.Repeat("█", filled) + strings.Repeat("░", usageBarWidth-filled) + "]"
+}
+
+// Returns an error with the appropriate exit code if any row reached one of the thresholds
+// (in percent; zero disables them).
+func checkUsageThresholds(rows []usageRow, warnAt, failAt float64) error {
+	var worst *usageRow
+	for i := range rows {
+		if worst == nil || rows[i].Percent > worst.Percent {
+			worst = &rows[i]
+		}
+	}
+	if worst == nil {
+		return nil
+	}
+	if failAt > 0 && worst.Percent >= failAt {
+		return cli.Exit(fmt.Sprintf("CRITICAL: %s usage at %.1f%% (threshold: %g%%)", strings.ToLower(worst.Label), worst.Percent, failAt), exitUsageCritical)
+	}
+	if warnAt > 0 && worst.Percent >= warnAt {
+		return cli.Exit(fmt.Sprintf("WARNING: %s usage at %.1f%% (threshold: %g%%)", strings.ToLower(worst.Label), worst.Percent, warnAt), exitUsageWarning)
+	}
+	return nil
+}
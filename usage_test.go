@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Omochice/deepl-translate-cli/deepl"
+	"github.com/urfave/cli/v2"
+)
+
+func TestUsageReport(t *testing.T) {
+	rows := usageRows(deepl.DeepLUsageResponse{CharacterCount: 450000, CharacterLimit: 500000})
+	if len(rows) != 1 || rows[0].Percent != 90 {
+		t.Fatalf("Only rows with a limit should be reported, got %#v", rows)
+	}
+
+	var b bytes.Buffer
+	if err := printUsage(&b, rows, outputTable); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !strings.Contains(b.String(), "450000 / 500000") || strings.Contains(b.String(), "Documents") {
+		t.Errorf("Unexpected table:\n%s", b.String())
+	}
+
+	b.Reset()
+	if err := printUsage(&b, rows, outputJSON); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	var report map[string]map[string]float64
+	if err := json.Unmarshal(b.Bytes(), &report); err != nil {
+		t.Fatalf("Invalid JSON output: %s\n%s", err, b.String())
+	}
+	if report["characters"]["limit"] != 500000 {
+		t.Errorf("Unexpected JSON output:\n%s", b.String())
+	}
+
+	exitCode := func(err error) int {
+		var exitErr cli.ExitCoder
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+		return 0
+	}
+	for _, c := range []struct {
+		warnAt, failAt	float64
+		expected		int
+	}{
+		{0, 0, 0},
+		{95, 0, 0},
+		{80, 95, exitUsageWarning},
+		{80, 90, exitUsageCritical},
+	} {
+		if code := exitCode(checkUsageThresholds(rows, c.warnAt, c.failAt)); code != c.expected {
+			t.Errorf("With --warn-at %g --fail-at %g, expected exit code %d, got %d", c.warnAt, c.failAt, c.expected, code)
+		}
+	}
+}
+
+func TestPoolUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+			case "DeepL-Auth-Key alice:fx":
+				json.NewEncoder(w).Encode(deepl.DeepLUsageResponse{CharacterCount: 500000, CharacterLimit: 500000})
+			case "DeepL-Auth-Key bob:fx":
+				json.NewEncoder(w).Encode(deepl.DeepLUsageResponse{CharacterCount: 100000, CharacterLimit: 500000})
+			default:
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]string{"message": "Wrong key"})
+		}
+	}))
+	defer server.Close()
+
+	pool := deepl.NewKeyPool([]deepl.PoolKey{
+		{Name: "alice", AuthKey: "alice:fx", BaseURL: server.URL},
+		{Name: "bob", AuthKey: "bob:fx", BaseURL: server.URL},
+		{Name: "carol", AuthKey: "carol:fx", BaseURL: server.URL},
+	})
+	keys, total := poolUsage(pool, 0)
+	if len(keys) != 3 || keys[2].Err == nil {
+		t.Fatalf("Expected three keys, the last one failing, got %#v", keys)
+	}
+	if total.CharacterCount != 600000 || total.CharacterLimit != 1000000 {
+		t.Errorf("Unexpected total: %#v", total)
+	}
+	if total, err := poolUsageTotal(pool, 0); err != nil || total.CharacterLimit != 1000000 {
+		t.Errorf("Keys that fail should be skipped in the total, got %#v (error: %v)", total, err)
+	}
+	failing := deepl.NewKeyPool([]deepl.PoolKey{{Name: "carol", AuthKey: "carol:fx", BaseURL: server.URL}})
+	if _, err := poolUsageTotal(failing, 0); err == nil || !strings.Contains(err.Error(), "any of the 1 keys") {
+		t.Errorf("Expected an error when no key's usage can be retrieved, got %v", err)
+	}
+
+	var b bytes.Buffer
+	if err := printPoolUsage(&b, keys, usageRows(total), outputTable); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, expected := range []string{"alice\n", "100000 / 500000", "Could not retrieve the usage", "Total\n", "600000 / 1000000"} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("Expected %q in the table:\n%s", expected, b.String())
+		}
+	}
+}